@@ -2,6 +2,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -12,10 +13,14 @@ import (
 
 var httpClient = &http.Client{Timeout: 10 * time.Second}
 
-// HTTP request to get Module data
-func GetModuleData(acadYear string, module string) ([]byte, error) {
+// HTTP request to get Module data, abandoned when ctx is done
+func GetModuleData(ctx context.Context, acadYear string, module string) ([]byte, error) {
 	url := fmt.Sprintf(constants.ModulesURL, acadYear, module)
-	res, err := httpClient.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -51,6 +51,44 @@ const (
 	GapPenaltyRate              = 100.0
 	LunchRequiredTime           = 60 // 1 hour in minutes
 	ConsecutiveHoursPenaltyRate = 100
+	CampusDayPenalty            = 500.0 // Per day with a physical lesson, when minimising campus days
+	BlackoutPenalty             = 200.0 // Per lesson slot in a soft blackout that sets no penalty
+	DailyHoursPenaltyRate       = 100.0 // Per hour over a soft maxHoursPerDay
+	BalancePenaltyRate          = 50.0  // Per hour between the busiest and lightest day, when balancing
+	TravelTimePenaltyRate       = 50.0  // Per minute a transit falls short of a soft travel time
+	CommutePenaltyRate          = 20.0  // Per km from homeLocation to a day's first lesson and back from its last
+	AvoidedVenuePenalty         = 200.0 // Per lesson slot in a soft avoided venue
+	UnpreferredVenuePenalty     = 50.0  // Per lesson slot outside the preferred venues, when some are set
+	ClassRankPenalty            = 100.0 // Per rank a class is below its lesson's first choice in classPreferences
+	ExcludedWeekPenalty         = 100.0 // Per lesson slot and soft excluded week it meets in
+)
+
+// DefaultScoringWeights are the heuristics used for any weight a request does not override
+var DefaultScoringWeights = models.ScoringWeights{
+	LunchBonus:                  LunchBonus,
+	NoLunchPenalty:              NoLunchPenalty,
+	GapPenaltyThreshold:         GapPenaltyThreshold,
+	GapPenaltyRate:              GapPenaltyRate,
+	ConsecutiveHoursPenaltyRate: ConsecutiveHoursPenaltyRate,
+	MaxWalkDistance:             MaxWalkDistance,
+	NoVenuePenalty:              NoVenuePenalty,
+	CampusDayPenalty:            CampusDayPenalty,
+	DailyHoursPenaltyRate:       DailyHoursPenaltyRate,
+	BalancePenaltyRate:          BalancePenaltyRate,
+	TravelTimePenaltyRate:       TravelTimePenaltyRate,
+	CommutePenaltyRate:          CommutePenaltyRate,
+	AvoidedVenuePenalty:         AvoidedVenuePenalty,
+	UnpreferredVenuePenalty:     UnpreferredVenuePenalty,
+	ClassRankPenalty:            ClassRankPenalty,
+	ExcludedWeekPenalty:         ExcludedWeekPenalty,
+}
+
+// Bounds on the scoring weights a request may set
+const (
+	MaxScoringWeight        = 10000.0 // Largest magnitude of any bonus, penalty or rate
+	MinWalkDistance         = 0.01    // 10 meters, so the walking penalty stays finite
+	MaxWalkDistanceOverride = 10.0    // 10 km
+	MaxGapPenaltyThreshold  = 24 * 60 // A whole day in minutes
 )
 
 const LessonParamsSeparator = ","
@@ -62,5 +100,70 @@ const (
 	DaysPerWeek     = 6
 )
 
+// Parallel beam expansion parameters
+const (
+	MaxBeamWorkers         = 16 // Upper bound on goroutines expanding a beam, in addition to the GOMAXPROCS bound
+	MinStatesPerBeamWorker = 64 // Beams smaller than this per worker are expanded with fewer workers
+)
+
+// Exact search parameters
+const (
+	// Requests with at most this many class combinations are solved exactly by branch-and-bound
+	ExactSearchMaxSpace = 200000
+	// Number of best complete timetables the exact search keeps to pick alternatives from
+	ExactSearchPoolSize = 200
+)
+
+// Local search refinement parameters
+const (
+	LocalSearchMaxEvaluations = 50000 // Candidate timetables scored before refinement stops
+	LocalSearchTimeLimitMs    = 2000  // Wall time budget for refinement
+	// Smallest score decrease accepted as an improvement, so floating point noise in the
+	// distance term cannot make the hill climbing cycle
+	LocalSearchMinImprovement = 1e-6
+)
+
+// Week-aware scoring parameters
+const (
+	// Teaching weeks told apart by weekScoring, one per bit of ModuleSlot.WeekMask. Lessons in
+	// later weeks only are not scored there
+	MaxTeachingWeeks = 64
+)
+
+// Optional module search parameters
+const (
+	MaxModuleCombinations           = 70   // Largest number of optionalModules combinations a request may ask for
+	CombinationBeamWidth            = 200  // Beam width used to screen each combination
+	CombinationTimeLimitMs          = 2000 // Wall time budget for screening the combinations
+	MaxModuleCombinationSuggestions = 3    // Runner-up combinations returned
+)
+
+// Recording recommendation parameters
+const (
+	MaxRecommendedRecordings = 5    // Upper bound on the lessons recommendRecordings marks as recorded
+	MaxRecordingEvaluations  = 40   // Requests with extra recordings solved before the search stops
+	RecordingBeamWidth       = 500  // Beam width used to solve each of them
+	RecordingTimeLimitMs     = 2000 // Wall time budget for solving them
+)
+
+// Relaxation search parameters
+const (
+	MaxRelaxationSuggestions = 3    // Relaxations returned when a request is infeasible
+	MaxRelaxationEvaluations = 40   // Relaxed requests solved before the search stops
+	RelaxationBeamWidth      = 500  // Beam width used to solve each relaxed request
+	RelaxationTimeLimitMs    = 2000 // Wall time budget for solving relaxed requests
+	RelaxationTimeStep       = 30   // Minutes per step when widening earliestTime/latestTime
+	RelaxationMaxTimeSteps   = 2    // Widen the time window by at most this many steps
+	RelaxationMaxHourSteps   = 2    // Raise maxHoursPerDay by at most this many hours
+)
+
+// Alternative timetable parameters
+const (
+	MaxAlternatives = 10 // Upper bound on the number of alternatives returned
+	// Minimum number of lessons in which an alternative must differ from the best
+	// timetable and every other alternative, so they are not near-copies
+	AlternativeMinHammingDistance = 2
+)
+
 // Indicates that a Coordinate was invalid
 var InvalidCoordinates = models.Coordinates{X: -1, Y: -1}
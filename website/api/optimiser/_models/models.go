@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -17,8 +18,13 @@ type ModuleDefaultSlotsMap = map[string]map[LessonType][]ModuleSlot
 
 type OptimiserRequest struct {
 	Modules             []string `json:"modules"`             // Format: ["CS1010S", "CS2030S"]
-	Recordings          []string `json:"recordings"`          // Format: ["CS1010S|Lecture", "CS2030S|Laboratory"]
+	OptionalModules     []string `json:"optionalModules"`     // Format: ["MA2001", "GEA1000"], candidates to choose from
+	ModuleCount         int      `json:"moduleCount"`         // Total modules to take, with optionalModules
+	Recordings          []string `json:"recordings"`          // Format: ["CS1010S|Lecture", "CS2030S|Lecture|02|Friday"]
+	RecommendRecordings int      `json:"recommendRecordings"` // Mark up to this many recordingCandidates as recorded
+	RecordingCandidates []string `json:"recordingCandidates"` // Format: ["CS1010S|Lecture"], every lecture if omitted
 	PinnedSlots         []string `json:"pinnedSlots"`         // Format: ["CS1010S|Tutorial|01"], classes the user has fixed
+	ExcludedSlots       []string `json:"excludedSlots"`       // Format: ["CS1010S|Tutorial|05"], classes never to assign
 	FreeDays            []string `json:"freeDays"`            // Format: ["Monday", "Tuesday"]
 	EarliestTime        string   `json:"earliestTime"`        // Format: "1504" (HHMM)
 	LatestTime          string   `json:"latestTime"`          // Format: "1504" (HHMM)
@@ -27,20 +33,80 @@ type OptimiserRequest struct {
 	MaxConsecutiveHours int      `json:"maxConsecutiveHours"` // Maximum consecutive hours of study
 	LunchStart          string   `json:"lunchStart"`          // Format: "1504" (HHMM)
 	LunchEnd            string   `json:"lunchEnd"`            // Format: "1500" (HHMM)
+	TimeLimitMs         int      `json:"timeLimitMs"`         // Optional solve deadline in milliseconds, 0 for none
+	Alternatives        int      `json:"alternatives"`        // Number of alternative timetables to return, 0 for none
+	MinimiseCampusDays  bool     `json:"minimiseCampusDays"`  // Penalise every day with a physical lesson
+	MaxHoursPerDay      float64  `json:"maxHoursPerDay"`      // Cap on physical contact hours per day, 0 for none
+	MaxHoursPerDaySoft  bool     `json:"maxHoursPerDaySoft"`  // Penalise hours over the cap instead
+	BalanceDailyHours   bool     `json:"balanceDailyHours"`   // Penalise uneven physical hours across days
+	WalkingSpeed        float64  `json:"walkingSpeed"`        // Metres per minute between lessons, 0 for no travel times
+	TravelTimeSoft      bool     `json:"travelTimeSoft"`      // Penalise too short transits instead
+	WeekScoring         string   `json:"weekScoring"`         // "mean" or "worst" to score each teaching week apart
+
+	Blackouts []Blackout `json:"blackouts"` // Recurring commitments physical lessons must (or should) avoid
+
+	ExcludedWeeks     []int `json:"excludedWeeks"`     // Format: [6, 7], teaching weeks the user is away
+	ExcludedWeeksSoft bool  `json:"excludedWeeksSoft"` // Penalise physical lessons in them instead
+
+	HomeLocation *HomeLocation `json:"homeLocation"` // Optional start and end of each day, for commute costs
+
+	// Format: ["COM1", "AS6-0214"], venues or buildings (the part of a venue before '-')
+	PreferredVenues []string `json:"preferredVenues"` // Physical lessons elsewhere are penalised
+	AvoidedVenues   []string `json:"avoidedVenues"`   // Physical lessons here are penalised
+	AvoidVenuesHard bool     `json:"avoidVenuesHard"` // Exclude lessons in AvoidedVenues instead
+
+	// Format: {"CS2030S|Laboratory": ["08", "03", "11"]}, the classes of a lesson in order of preference
+	ClassPreferences      map[string][]string `json:"classPreferences"`
+	RestrictToPreferences bool                `json:"restrictToPreferences"` // Only allow the listed classes
+
+	// Format: {"Monday": {"earliestTime": "1000"}, "Friday": {"latestTime": "1600"}}, overrides the global window
+	DayTimeWindows map[string]TimeWindow `json:"dayTimeWindows"`
+
+	Weights WeightOverrides `json:"weights"` // Optional overrides of the scoring heuristics
+
+	// Resolved fields, set by the solver from Weights and the defaults in _constants
+	ScoringWeights ScoringWeights `json:"-"`
 
 	// Parsed fields
-	EarliestMin   int                `json:"-"`
-	LatestMin     int                `json:"-"`
-	LunchStartMin int                `json:"-"`
-	LunchEndMin   int                `json:"-"`
-	PinnedMap     map[string]ClassNo `json:"-"` // lessonKey ("MODULE|LessonType") -> pinned ClassNo
+	EarliestMin    int                `json:"-"`
+	LatestMin      int                `json:"-"`
+	LunchStartMin  int                `json:"-"`
+	LunchEndMin    int                `json:"-"`
+	PinnedMap      map[string]ClassNo `json:"-"` // lessonKey ("MODULE|LessonType") -> pinned ClassNo
+	RecordingRules []RecordingRule    `json:"-"` // Recordings, parsed
+	DayEarliestMin [6]int             `json:"-"` // Per day (Mon–Sat): EarliestMin unless overridden by DayTimeWindows
+	DayLatestMin   [6]int             `json:"-"` // Per day (Mon–Sat): LatestMin unless overridden by DayTimeWindows
+	IsFreeDay      [6]bool            `json:"-"` // Per day (Mon–Sat): whether it is in FreeDays
+
+	// lessonKey -> ClassNo -> rank in ClassPreferences, 0 for the first choice
+	ClassRanks map[string]map[ClassNo]int `json:"-"`
+	// lessonKey -> ClassNos in ExcludedSlots
+	ExcludedMap map[string]map[ClassNo]struct{} `json:"-"`
+	// RecordingCandidates as lessonKeys ("MODULE|LessonType")
+	RecordingCandidateKeys []string `json:"-"`
+
+	// Resolved from HomeLocation when building the search space, since a venue needs venues.json
+	HomeCoordinates Coordinates `json:"-"`
+	// Resolved from the timetables of Modules when building the search space: every week one
+	// of their classes runs in, ascending
+	TeachingWeeks []int `json:"-"`
 }
 
+// How lessons that run in some teaching weeks only are scored, as set in weekScoring. Without
+// either, every lesson is scored as if it ran every week.
+const (
+	WeekScoringMean  = "mean"  // The mean of every teaching week's score
+	WeekScoringWorst = "worst" // The score of the worst teaching week
+)
+
 // ParseOptimiserRequestFields validates and parses time fields into minutes.
 func (r *OptimiserRequest) ParseOptimiserRequestFields() error {
-	if len(r.Modules) == 0 {
+	if len(r.Modules) == 0 && len(r.OptionalModules) == 0 {
 		return fmt.Errorf("at least one module must be provided")
 	}
+	if err := r.validateOptionalModules(); err != nil {
+		return err
+	}
 	var err error
 	r.EarliestMin, err = ParseTimeToMinutes(r.EarliestTime)
 	if err != nil {
@@ -59,23 +125,272 @@ func (r *OptimiserRequest) ParseOptimiserRequestFields() error {
 		return fmt.Errorf("invalid lunchEnd: %s", r.LunchEnd)
 	}
 
+	if r.TimeLimitMs < 0 {
+		return fmt.Errorf("invalid timeLimitMs: %d", r.TimeLimitMs)
+	}
+	if r.Alternatives < 0 {
+		return fmt.Errorf("invalid alternatives: %d", r.Alternatives)
+	}
+	if r.MaxHoursPerDay < 0 || r.MaxHoursPerDay > 24 {
+		return fmt.Errorf("invalid maxHoursPerDay: %v", r.MaxHoursPerDay)
+	}
+	if r.WalkingSpeed < 0 {
+		return fmt.Errorf("invalid walkingSpeed: %v", r.WalkingSpeed)
+	}
+	for _, week := range r.ExcludedWeeks {
+		if week < 1 {
+			return fmt.Errorf("invalid excludedWeeks week: %d", week)
+		}
+	}
+	switch r.WeekScoring {
+	case "", WeekScoringMean, WeekScoringWorst:
+	default:
+		return fmt.Errorf("invalid weekScoring: %s", r.WeekScoring)
+	}
+
 	// TODO: Time range validation for earliest time, latest time, lunch start time, lunch end time
 	// Ensure earlier time <= later time. Currently not ensured in frontend yet. Once that is completed
 	// we can add this check for completion.
 
+	if err = r.ParseRecordings(); err != nil {
+		return err
+	}
+	if err = r.parseRecordingCandidates(); err != nil {
+		return err
+	}
 	if err = r.parsePinnedSlots(); err != nil {
 		return err
 	}
+	if err = r.parseClassPreferences(); err != nil {
+		return err
+	}
+	if err = r.parseExcludedSlots(); err != nil {
+		return err
+	}
+	if err = r.parseBlackouts(); err != nil {
+		return err
+	}
+	if err = r.parseDayTimeWindows(); err != nil {
+		return err
+	}
+	if err = r.HomeLocation.validate(); err != nil {
+		return err
+	}
+	if err = r.validateVenuePreferences(); err != nil {
+		return err
+	}
+
+	r.IsFreeDay = [6]bool{}
+	for _, freeDay := range r.FreeDays {
+		if dayIdx, ok := dayToIndex[strings.ToUpper(freeDay)]; ok {
+			r.IsFreeDay[dayIdx] = true
+		}
+	}
+	return nil
+}
+
+// parseDayTimeWindows fills DayEarliestMin and DayLatestMin from the global time window and
+// the per-day overrides in DayTimeWindows.
+func (r *OptimiserRequest) parseDayTimeWindows() error {
+	for d := range r.DayEarliestMin {
+		r.DayEarliestMin[d] = r.EarliestMin
+		r.DayLatestMin[d] = r.LatestMin
+	}
+	for day, window := range r.DayTimeWindows {
+		d, ok := DayIndex(day)
+		if !ok {
+			return fmt.Errorf("invalid dayTimeWindows day: %s", day)
+		}
+		if window.EarliestTime != "" {
+			earliestMin, err := ParseTimeToMinutes(window.EarliestTime)
+			if err != nil {
+				return fmt.Errorf("invalid earliestTime for %s: %s", day, window.EarliestTime)
+			}
+			r.DayEarliestMin[d] = earliestMin
+		}
+		if window.LatestTime != "" {
+			latestMin, err := ParseTimeToMinutes(window.LatestTime)
+			if err != nil {
+				return fmt.Errorf("invalid latestTime for %s: %s", day, window.LatestTime)
+			}
+			r.DayLatestMin[d] = latestMin
+		}
+		if r.DayEarliestMin[d] >= r.DayLatestMin[d] {
+			return fmt.Errorf("empty time window for %s", day)
+		}
+	}
+	return nil
+}
+
+// validateVenuePreferences rejects an empty venue and a venue both preferred and avoided.
+func (r *OptimiserRequest) validateVenuePreferences() error {
+	preferred := make(map[string]struct{}, len(r.PreferredVenues))
+	for _, venue := range r.PreferredVenues {
+		if venue == "" {
+			return fmt.Errorf("invalid preferredVenues: empty venue")
+		}
+		preferred[strings.ToUpper(venue)] = struct{}{}
+	}
+	for _, venue := range r.AvoidedVenues {
+		if venue == "" {
+			return fmt.Errorf("invalid avoidedVenues: empty venue")
+		}
+		if _, ok := preferred[strings.ToUpper(venue)]; ok {
+			return fmt.Errorf("venue %s is both preferred and avoided", venue)
+		}
+	}
+	return nil
+}
+
+// parseBlackouts validates Blackouts and parses their days, times and weeks.
+func (r *OptimiserRequest) parseBlackouts() error {
+	for i := range r.Blackouts {
+		blackout := &r.Blackouts[i]
+		dayIdx, ok := dayToIndex[strings.ToUpper(blackout.Day)]
+		if !ok {
+			return fmt.Errorf("invalid blackout day: %s", blackout.Day)
+		}
+		startMin, err1 := ParseTimeToMinutes(blackout.StartTime)
+		endMin, err2 := ParseTimeToMinutes(blackout.EndTime)
+		if err1 != nil || err2 != nil || startMin >= endMin {
+			return fmt.Errorf("invalid blackout time range: %s-%s", blackout.StartTime, blackout.EndTime)
+		}
+		if blackout.Penalty < 0 {
+			return fmt.Errorf("invalid blackout penalty: %v", blackout.Penalty)
+		}
+
+		// NUSMods capitalises day names, e.g. "Tuesday"
+		blackout.Day = strings.ToUpper(blackout.Day[:1]) + strings.ToLower(blackout.Day[1:])
+		blackout.DayIndex = dayIdx
+		blackout.StartMin = startMin
+		blackout.EndMin = endMin
+		blackout.WeeksSet = nil
+		if len(blackout.Weeks) > 0 {
+			blackout.WeeksSet = make(map[int]struct{}, len(blackout.Weeks))
+			for _, week := range blackout.Weeks {
+				if week < 1 {
+					return fmt.Errorf("invalid blackout week: %d", week)
+				}
+				blackout.WeeksSet[week] = struct{}{}
+			}
+		}
+	}
+	return nil
+}
+
+// requestModules returns the upper-cased Modules and OptionalModules as a set.
+func (r *OptimiserRequest) requestModules() map[string]struct{} {
+	modules := make(map[string]struct{}, len(r.Modules)+len(r.OptionalModules))
+	for _, module := range slices.Concat(r.Modules, r.OptionalModules) {
+		modules[strings.ToUpper(module)] = struct{}{}
+	}
+	return modules
+}
+
+// validateOptionalModules checks that OptionalModules neither repeat nor overlap Modules, and
+// that ModuleCount takes at least one and at most all of them.
+func (r *OptimiserRequest) validateOptionalModules() error {
+	if len(r.OptionalModules) == 0 {
+		if r.ModuleCount != 0 {
+			return fmt.Errorf("moduleCount requires optionalModules")
+		}
+		return nil
+	}
+	if len(r.requestModules()) != len(r.Modules)+len(r.OptionalModules) {
+		return fmt.Errorf("optionalModules must not repeat a module")
+	}
+	if r.ModuleCount <= len(r.Modules) || r.ModuleCount > len(r.Modules)+len(r.OptionalModules) {
+		return fmt.Errorf(
+			"invalid moduleCount: %d, must be more than the %d modules and at most %d",
+			r.ModuleCount,
+			len(r.Modules),
+			len(r.Modules)+len(r.OptionalModules),
+		)
+	}
+	return nil
+}
+
+// RecordingRule is a parsed Recordings entry. The lessons it matches are watched as
+// recordings, so they are not attended in person. Empty fields match every class, day or week.
+type RecordingRule struct {
+	LessonKey string           // "MODULE|LessonType"
+	ClassNo   ClassNo          // Only this class of the lesson, if set
+	Day       string           // Only the lessons on this day (e.g. "Friday"), if set
+	WeeksSet  map[int]struct{} // Only the lessons in these teaching weeks, if set
+}
+
+// ParseRecordings validates Recordings entries ("MODULE|LessonType", optionally followed by
+// "|ClassNo", "|Day" and "|Weeks" with comma-separated teaching weeks, any of which may be
+// left empty) and builds RecordingRules. Entries of modules not in the request match nothing.
+// It is exported so the solver can add recordings to a parsed request.
+func (r *OptimiserRequest) ParseRecordings() error {
+	r.RecordingRules = make([]RecordingRule, 0, len(r.Recordings))
+	for _, recording := range r.Recordings {
+		parts := strings.Split(recording, "|")
+		if len(parts) < 2 || len(parts) > 5 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid recording format: %s", recording)
+		}
+		parts = append(parts, make([]string, 5-len(parts))...)
+		rule := RecordingRule{
+			LessonKey: strings.ToUpper(parts[0]) + "|" + parts[1],
+			ClassNo:   parts[2],
+		}
+		if day := parts[3]; day != "" {
+			if _, ok := dayToIndex[strings.ToUpper(day)]; !ok {
+				return fmt.Errorf("invalid recording day: %s", recording)
+			}
+			// NUSMods capitalises day names, e.g. "Tuesday"
+			rule.Day = strings.ToUpper(day[:1]) + strings.ToLower(day[1:])
+		}
+		if parts[4] != "" {
+			rule.WeeksSet = make(map[int]struct{})
+			for _, week := range strings.Split(parts[4], ",") {
+				weekNum, err := strconv.Atoi(strings.TrimSpace(week))
+				if err != nil || weekNum < 1 {
+					return fmt.Errorf("invalid recording week: %s", recording)
+				}
+				rule.WeeksSet[weekNum] = struct{}{}
+			}
+		}
+		r.RecordingRules = append(r.RecordingRules, rule)
+	}
+	return nil
+}
+
+// parseRecordingCandidates validates RecordingCandidates entries ("MODULE|LessonType") and
+// builds RecordingCandidateKeys. Candidates are only allowed with RecommendRecordings.
+func (r *OptimiserRequest) parseRecordingCandidates() error {
+	if r.RecommendRecordings < 0 {
+		return fmt.Errorf("invalid recommendRecordings: %d", r.RecommendRecordings)
+	}
+	if len(r.RecordingCandidates) > 0 && r.RecommendRecordings == 0 {
+		return fmt.Errorf("recordingCandidates requires recommendRecordings")
+	}
+	requestModules := r.requestModules()
+
+	r.RecordingCandidateKeys = make([]string, 0, len(r.RecordingCandidates))
+	for _, candidate := range r.RecordingCandidates {
+		parts := strings.SplitN(candidate, "|", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.Contains(parts[1], "|") {
+			return fmt.Errorf("invalid recordingCandidate format: %s", candidate)
+		}
+		module := strings.ToUpper(parts[0])
+		if _, ok := requestModules[module]; !ok {
+			return fmt.Errorf("recording candidate %s references module not in request", candidate)
+		}
+		lessonKey := module + "|" + parts[1]
+		if slices.Contains(r.RecordingCandidateKeys, lessonKey) {
+			return fmt.Errorf("duplicate recording candidate %s", lessonKey)
+		}
+		r.RecordingCandidateKeys = append(r.RecordingCandidateKeys, lessonKey)
+	}
 	return nil
 }
 
 // parsePinnedSlots validates PinnedSlots entries ("MODULE|LessonType|ClassNo") and
 // builds PinnedMap keyed by lessonKey ("MODULE|LessonType").
 func (r *OptimiserRequest) parsePinnedSlots() error {
-	requestModules := make(map[string]struct{}, len(r.Modules))
-	for _, module := range r.Modules {
-		requestModules[strings.ToUpper(module)] = struct{}{}
-	}
+	requestModules := r.requestModules()
 
 	r.PinnedMap = make(map[string]ClassNo, len(r.PinnedSlots))
 	for _, pinnedSlot := range r.PinnedSlots {
@@ -96,6 +411,167 @@ func (r *OptimiserRequest) parsePinnedSlots() error {
 	return nil
 }
 
+// parseClassPreferences validates ClassPreferences keys ("MODULE|LessonType") and class
+// lists, and builds ClassRanks keyed by lessonKey. A pinned lesson cannot also be ranked.
+// Whether the classes exist is checked against the module timetables when they are fetched.
+func (r *OptimiserRequest) parseClassPreferences() error {
+	requestModules := r.requestModules()
+
+	r.ClassRanks = make(map[string]map[ClassNo]int, len(r.ClassPreferences))
+	for key, classNos := range r.ClassPreferences {
+		parts := strings.SplitN(key, "|", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid classPreferences lesson format: %s", key)
+		}
+		module, lessonType := strings.ToUpper(parts[0]), parts[1]
+		if _, ok := requestModules[module]; !ok {
+			return fmt.Errorf("class preferences for %s reference module not in request", key)
+		}
+		lessonKey := module + "|" + lessonType
+		if _, ok := r.PinnedMap[lessonKey]; ok {
+			return fmt.Errorf("lesson %s is both pinned and has class preferences", lessonKey)
+		}
+		if _, ok := r.ClassRanks[lessonKey]; ok || len(classNos) == 0 {
+			return fmt.Errorf("invalid class preferences for %s", lessonKey)
+		}
+
+		ranks := make(map[ClassNo]int, len(classNos))
+		for rank, classNo := range classNos {
+			if _, ok := ranks[classNo]; ok || classNo == "" {
+				return fmt.Errorf("invalid class %q in preferences for %s", classNo, lessonKey)
+			}
+			ranks[classNo] = rank
+		}
+		r.ClassRanks[lessonKey] = ranks
+	}
+	return nil
+}
+
+// parseExcludedSlots validates ExcludedSlots entries ("MODULE|LessonType|ClassNo") like
+// parsePinnedSlots, and builds ExcludedMap keyed by lessonKey. Excluding a pinned class is
+// rejected, since no timetable could satisfy both.
+func (r *OptimiserRequest) parseExcludedSlots() error {
+	requestModules := r.requestModules()
+
+	r.ExcludedMap = make(map[string]map[ClassNo]struct{}, len(r.ExcludedSlots))
+	for _, excludedSlot := range r.ExcludedSlots {
+		parts := strings.SplitN(excludedSlot, "|", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return fmt.Errorf("invalid excludedSlot format: %s", excludedSlot)
+		}
+		module, lessonType, classNo := strings.ToUpper(parts[0]), parts[1], parts[2]
+		if _, ok := requestModules[module]; !ok {
+			return fmt.Errorf("excluded slot %s references module not in request", excludedSlot)
+		}
+		lessonKey := module + "|" + lessonType
+		if pinnedClassNo, ok := r.PinnedMap[lessonKey]; ok && pinnedClassNo == classNo {
+			return fmt.Errorf("excluded slot %s is also pinned", excludedSlot)
+		}
+		if r.ExcludedMap[lessonKey] == nil {
+			r.ExcludedMap[lessonKey] = make(map[ClassNo]struct{})
+		}
+		if _, ok := r.ExcludedMap[lessonKey][classNo]; ok {
+			return fmt.Errorf("duplicate excluded slot %s", excludedSlot)
+		}
+		r.ExcludedMap[lessonKey][classNo] = struct{}{}
+	}
+	return nil
+}
+
+// WeightOverrides overrides the scoring heuristics of a single request. Omitted (nil)
+// fields keep the default weight in _constants.
+type WeightOverrides struct {
+	LunchBonus                  *float64 `json:"lunchBonus"`                  // Added per day with a lunch break, <= 0
+	NoLunchPenalty              *float64 `json:"noLunchPenalty"`              // Per day without a lunch break, >= 0
+	GapPenaltyThreshold         *int     `json:"gapPenaltyThreshold"`         // Minutes of gap before it is penalised
+	GapPenaltyRate              *float64 `json:"gapPenaltyRate"`              // Per hour of gap over the threshold
+	ConsecutiveHoursPenaltyRate *float64 `json:"consecutiveHoursPenaltyRate"` // Per hour over maxConsecutiveHours
+	MaxWalkDistance             *float64 `json:"maxWalkDistance"`             // km of walking that costs 10 points
+	NoVenuePenalty              *float64 `json:"noVenuePenalty"`              // Per walk to or from an unknown venue
+	CampusDayPenalty            *float64 `json:"campusDayPenalty"`            // Per day on campus if minimiseCampusDays
+	DailyHoursPenaltyRate       *float64 `json:"dailyHoursPenaltyRate"`       // Per hour over a soft maxHoursPerDay
+	BalancePenaltyRate          *float64 `json:"balancePenaltyRate"`          // Per hour between busiest and lightest day
+	TravelTimePenaltyRate       *float64 `json:"travelTimePenaltyRate"`       // Per minute short of a soft travel time
+	CommutePenaltyRate          *float64 `json:"commutePenaltyRate"`          // Per km between home and campus
+	AvoidedVenuePenalty         *float64 `json:"avoidedVenuePenalty"`         // Per slot in a soft avoided venue
+	UnpreferredVenuePenalty     *float64 `json:"unpreferredVenuePenalty"`     // Per slot outside preferredVenues
+	ClassRankPenalty            *float64 `json:"classRankPenalty"`            // Per rank below a lesson's first choice
+	ExcludedWeekPenalty         *float64 `json:"excludedWeekPenalty"`         // Per slot and soft excluded week met in
+}
+
+// ScoringWeights are the scoring heuristics used for a request, after applying its
+// WeightOverrides to the defaults.
+type ScoringWeights struct {
+	LunchBonus                  float64
+	NoLunchPenalty              float64
+	GapPenaltyThreshold         int
+	GapPenaltyRate              float64
+	ConsecutiveHoursPenaltyRate float64
+	MaxWalkDistance             float64
+	NoVenuePenalty              float64
+	CampusDayPenalty            float64
+	DailyHoursPenaltyRate       float64
+	BalancePenaltyRate          float64
+	TravelTimePenaltyRate       float64
+	CommutePenaltyRate          float64
+	AvoidedVenuePenalty         float64
+	UnpreferredVenuePenalty     float64
+	ClassRankPenalty            float64
+	ExcludedWeekPenalty         float64
+}
+
+// TimeWindow overrides the global earliestTime/latestTime for a single day. An empty field
+// keeps the global value.
+type TimeWindow struct {
+	EarliestTime string `json:"earliestTime"` // Format: "1000" (HHMM)
+	LatestTime   string `json:"latestTime"`   // Format: "1600" (HHMM)
+}
+
+// HomeLocation is where the student starts and ends each day on campus, given as either a
+// venue or coordinates.
+type HomeLocation struct {
+	Venue       string       `json:"venue"`       // Format: "UT-AUD1", a venue in venues.json
+	Coordinates *Coordinates `json:"coordinates"` // Format: {"x": 103.7736, "y": 1.3056} (longitude, latitude)
+}
+
+// validate checks that exactly one of Venue and Coordinates is set, and that the coordinates
+// are a valid longitude and latitude. A nil HomeLocation is valid.
+func (h *HomeLocation) validate() error {
+	if h == nil {
+		return nil
+	}
+	if (h.Venue == "") == (h.Coordinates == nil) {
+		return fmt.Errorf("homeLocation needs exactly one of venue and coordinates")
+	}
+	if c := h.Coordinates; c != nil && (c.X < -180 || c.X > 180 || c.Y < -90 || c.Y > 90) {
+		return fmt.Errorf("invalid homeLocation coordinates: %v, %v", c.X, c.Y)
+	}
+	return nil
+}
+
+// Blackout is a recurring time window, e.g. a CCA every Tuesday 1800-2000, that physical
+// lessons must not overlap. A soft blackout may be overlapped at a penalty instead.
+type Blackout struct {
+	Day       string  `json:"day"`       // Format: "Tuesday"
+	StartTime string  `json:"startTime"` // Format: "1800" (HHMM)
+	EndTime   string  `json:"endTime"`   // Format: "2000" (HHMM)
+	Weeks     []int   `json:"weeks"`     // Optional teaching weeks it applies to, e.g. [1, 2, 3]; every week if empty
+	Soft      bool    `json:"soft"`      // Penalise overlapping lessons instead of excluding them
+	Penalty   float64 `json:"penalty"`   // Soft only: score added per overlapping lesson slot, 0 for the default
+
+	// Parsed fields
+	DayIndex int              `json:"-"`
+	StartMin int              `json:"-"`
+	EndMin   int              `json:"-"`
+	WeeksSet map[int]struct{} `json:"-"` // nil if it applies to every week
+}
+
+// String describes the blackout as reported in UnassignedLesson.FilteredBy, e.g.
+// "Tuesday 1800-2000".
+func (b Blackout) String() string {
+	return b.Day + " " + b.StartTime + "-" + b.EndTime
+}
+
 // SolveError is returned by Solve to communicate both the error message and the
 // appropriate HTTP status code to the handler
 type SolveError struct {
@@ -109,6 +585,144 @@ type SolveResponse struct {
 	TimetableState
 	ShareableLink        string `json:"shareableLink"`
 	DefaultShareableLink string `json:"defaultShareableLink"`
+
+	// Truncated is set when the solve hit its deadline before every lesson was
+	// considered, so the timetable is the best partial state found so far.
+	Truncated bool `json:"truncated"`
+	// ProvenOptimal is set when the exact search ran to completion, so no other timetable
+	// that assigns every lesson scores better.
+	ProvenOptimal bool `json:"provenOptimal"`
+	// LocalSearchImprovement is how much the local search refinement lowered the score of
+	// the beam search result (0 if it found no improving swap or did not run). It can be
+	// negative when refinement also assigned a lesson, see LocalSearchLessonsGained.
+	LocalSearchImprovement float64 `json:"localSearchImprovement"`
+	// LocalSearchLessonsGained is how many lessons skipped by beam search the local search
+	// refinement assigned after a swap freed their slot.
+	LocalSearchLessonsGained int `json:"localSearchLessonsGained"`
+	// UnassignedLessons lists the lessons missing from the solved timetable and why, so
+	// the user can tell which preferences cannot be met. These lessons are filled from
+	// the default slots in defaultShareableLink.
+	UnassignedLessons []UnassignedLesson `json:"unassignedLessons"`
+	// Alternatives are other complete timetables, best first, that each differ from the
+	// returned timetable and from one another in several lessons.
+	Alternatives []Alternative `json:"alternatives"`
+	// Relaxations are the smallest changes to the request found that make every lesson
+	// assignable, smallest first. Only searched for when a lesson is filtered or clashes.
+	Relaxations []Relaxation `json:"relaxations"`
+	// ScoreBreakdown splits Score by day and scoring component.
+	ScoreBreakdown ScoreBreakdown `json:"scoreBreakdown"`
+	// DayHours are the physical (non-recorded) contact hours of each day (Mon–Sat).
+	DayHours [6]float64 `json:"dayHours"`
+	// ChosenModules are the optionalModules the timetable takes, in request order.
+	ChosenModules []string `json:"chosenModules"`
+	// ModuleCombinations are the runner-up choices of optionalModules, best first.
+	ModuleCombinations []ModuleCombination `json:"moduleCombinations"`
+	// RecommendedRecordings are the lessons marked as recorded for recommendRecordings, in the
+	// order picked. The timetable is solved with them recorded.
+	RecommendedRecordings []RecordingRecommendation `json:"recommendedRecordings"`
+}
+
+// RecordingRecommendation is a lesson recommended to be watched as a recording.
+type RecordingRecommendation struct {
+	Lesson string `json:"lesson"` // lessonKey, e.g. "CS1010S|Lecture", as added to recordings
+	// How much recording the lesson lowered the score of the screening solve, which uses a
+	// narrower beam than the returned timetable. It may be negative if the lesson made
+	// more lessons assignable, since each assigned lesson adds to the score
+	ScoreGain     float64 `json:"scoreGain"`
+	LessonsGained int     `json:"lessonsGained"` // Lessons that recording the lesson made assignable
+}
+
+// ModuleCombination is a choice of optionalModules that was screened but not picked.
+type ModuleCombination struct {
+	Modules []string `json:"modules"` // The optionalModules taken, in request order
+	// Score of the screening solve, which uses a narrower beam than the returned timetable,
+	// so it is only comparable between combinations
+	Score float64 `json:"score"`
+	// Whether the screening solve assigned every lesson
+	Complete bool `json:"complete"`
+}
+
+// ScoreBreakdown splits a timetable's score by scoring component. Every map is keyed by
+// component name (e.g. "lunch", "distance"), and the values of Total add up to the score.
+type ScoreBreakdown struct {
+	Days  [6]map[string]float64 `json:"days"`  // Per day (Mon–Sat), each component's score for that day
+	Week  map[string]float64    `json:"week"`  // Each component's score not attributable to a single day
+	Total map[string]float64    `json:"total"` // Each component's score over the whole week
+}
+
+// Kinds of change a Relaxation can make to the request.
+const (
+	RelaxationDropFreeDay  = "dropFreeDay"  // Value: the day no longer kept free, e.g. "Monday"
+	RelaxationEarlierStart = "earlierStart" // Value: the widened earliestTime, e.g. "0830"
+	RelaxationLaterEnd     = "laterEnd"     // Value: the widened latestTime, e.g. "1930"
+	RelaxationRecordLesson = "recordLesson" // Value: the lecture marked as recorded, e.g. "CS1010S|Lecture"
+	RelaxationUnpinSlot    = "unpinSlot"    // Value: the pinned slot dropped, e.g. "CS2030S|Tutorial|03"
+	RelaxationIncludeSlot  = "includeSlot"  // Value: the excluded slot allowed again, e.g. "CS2030S|Tutorial|05"
+	RelaxationSoftBlackout = "softBlackout" // Value: the hard blackout made soft, e.g. "Tuesday 1800-2000"
+	RelaxationMoreHours    = "moreHours"    // Value: the raised maxHoursPerDay, e.g. "7"
+	RelaxationSoftTravel   = "softTravel"   // Value: the walkingSpeed whose travel times became soft, e.g. "80"
+	RelaxationSoftVenues   = "softVenues"   // Value: the avoidedVenues made soft, e.g. "COM1,LT17"
+	RelaxationSoftWeeks    = "softWeeks"    // Value: the excludedWeeks made soft, e.g. "6,7"
+)
+
+// RelaxationChange is a single change to the request's preferences.
+type RelaxationChange struct {
+	Kind  string `json:"kind"`  // One of the Relaxation kinds
+	Value string `json:"value"` // What the change applies to, see the Relaxation kinds
+}
+
+// Relaxation is a set of changes to the request that makes every lesson assignable, with
+// the timetable found for the relaxed request.
+type Relaxation struct {
+	Changes       []RelaxationChange `json:"changes"`
+	Score         float64            `json:"Score"`
+	ShareableLink string             `json:"shareableLink"`
+}
+
+// Reasons a lesson can be left without a class in the solved timetable.
+const (
+	// Every class of the lesson was removed by a hard constraint, see FilteredBy
+	UnassignedReasonFiltered = "filtered"
+	// Every remaining class of the lesson clashes with an assigned lesson, see ClashesWith
+	UnassignedReasonClash = "clash"
+	// The solve hit its deadline before assigning the lesson
+	UnassignedReasonTimeLimit = "timeLimit"
+	// Every class of the lesson that does not clash would take a day over a hard maxHoursPerDay
+	UnassignedReasonMaxHoursPerDay = "maxHoursPerDay"
+	// Every class of the lesson that fits within maxHoursPerDay leaves too little time to
+	// walk to or from an assigned lesson at walkingSpeed
+	UnassignedReasonTravelTime = "travelTime"
+	// Some class of the lesson still fits, but the search pruned every timetable that
+	// assigned it and refinement did not reach one
+	UnassignedReasonSearchPruned = "searchPruned"
+)
+
+// Hard constraints that can filter out a class, as reported in UnassignedLesson.FilteredBy.
+const (
+	FilterReasonFreeDay   = "freeDay"   // Reported as "freeDay:<Day>", e.g. "freeDay:Monday"
+	FilterReasonTimeRange = "timeRange" // Outside earliestTime/latestTime
+	FilterReasonBlackout  = "blackout"  // Reported as "blackout:<Blackout>", e.g. "blackout:Tuesday 1800-2000"
+	// Reported as "avoidedVenue:<entry>", e.g. "avoidedVenue:COM1", with avoidVenuesHard
+	FilterReasonAvoidedVenue = "avoidedVenue"
+	// The class is in excludedSlots
+	FilterReasonExcluded = "excluded"
+	// Reported as "excludedWeek:<week>", e.g. "excludedWeek:6", without excludedWeeksSoft
+	FilterReasonExcludedWeek = "excludedWeek"
+)
+
+// UnassignedLesson explains why a lesson has no class in the solved timetable.
+type UnassignedLesson struct {
+	LessonKey   string   `json:"lessonKey"`             // "MODULE|LessonType"
+	Reason      string   `json:"reason"`                // One of the UnassignedReason values
+	FilteredBy  []string `json:"filteredBy,omitempty"`  // Sorted FilterReason values that removed its classes
+	ClashesWith []string `json:"clashesWith,omitempty"` // Sorted assigned lessonKeys clashing with its classes
+}
+
+// Alternative is a complete timetable offered alongside the best one.
+type Alternative struct {
+	Assignments   map[string]string `json:"Assignments"` // lessonKey -> chosen classNo
+	Score         float64           `json:"Score"`
+	ShareableLink string            `json:"shareableLink"`
 }
 
 type TimetableState struct {
@@ -116,11 +730,21 @@ type TimetableState struct {
 	DaySlots      [6][]ModuleSlot   `json:"DaySlots"`      // For each day, a time-sorted slice of slots
 	DayDistance   [6]float64        `json:"DayDistance"`   // Per-day walking penalty score (sum of haversine distances between consecutive physical lessons)
 	TotalDistance float64           `json:"TotalDistance"` // Sum of all DayDistance
+	// The timetable of each group of teaching weeks holding the same lessons, kept up to date
+	// as lessons are assigned with weekScoring; nil otherwise
+	WeekGroups []WeekGroup `json:"-"`
 
 	// Calculated fields
 	Score float64 `json:"Score"`
 }
 
+// WeekGroup is the part of a timetable held in a group of teaching weeks. Groups are never
+// changed in place, so states may share them.
+type WeekGroup struct {
+	Mask     uint64          // Bit i is set if the group holds the request's TeachingWeeks[i]
+	DaySlots [6][]ModuleSlot // For each day, a time-sorted slice of the slots held in these weeks
+}
+
 type ModuleSlot struct {
 	ClassNo     ClassNo     `json:"classNo"`
 	Day         string      `json:"day"`
@@ -138,6 +762,20 @@ type ModuleSlot struct {
 	LessonKey   string           `json:"LessonKey"` // "MODULE|LessonType"
 	WeeksSet    map[int]struct{} `json:"WeeksSet"`
 	WeeksString string           `json:"WeeksString"`
+	Penalty     float64          `json:"Penalty"`  // Soft penalty for attending the slot, e.g. in a soft blackout
+	Recorded    bool             `json:"Recorded"` // Watched as a recording (see RecordingRule), not attended
+	WeekMask    uint64           `json:"-"`        // Bit i is set if the slot runs in the request's TeachingWeeks[i]
+	// WeeksSet holds the calendar weeks of a WeekRange's dates rather than teaching weeks
+	CalendarWeeks bool `json:"-"`
+}
+
+// WeekRange is the form of ModuleSlot.Weeks for lessons held on dates rather than teaching
+// weeks, e.g. {"start": "2025-01-13", "end": "2025-04-14", "weekInterval": 2}.
+type WeekRange struct {
+	Start        string `json:"start"`        // Format: "2025-01-13", the date of the first lesson
+	End          string `json:"end"`          // Format: "2025-04-14", no lesson is held after it
+	WeekInterval int    `json:"weekInterval"` // Weeks between lessons, 1 if omitted
+	Weeks        []int  `json:"weeks"`        // Optional weeks counted from Start (1 for Start) to hold lessons in
 }
 
 // ParseModuleSlotFields parses and populates the parsed fields in ModuleSlot for faster computation
@@ -182,6 +820,18 @@ var dayToIndex = map[string]int{
 
 // Helper Functions
 
+// DayName returns the weekday name of a day index (0=Monday ... 5=Saturday), as NUSMods
+// spells it.
+func DayName(dayIdx int) string {
+	return [...]string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}[dayIdx]
+}
+
+// DayIndex returns the index (0=Monday ... 5=Saturday) of a weekday name, in any case.
+func DayIndex(day string) (int, bool) {
+	dayIdx, ok := dayToIndex[strings.ToUpper(day)]
+	return dayIdx, ok
+}
+
 // ParseTimeToMinutes converts "HHMM" to minutes since midnight, with error checking.
 func ParseTimeToMinutes(timeStr string) (int, error) {
 	if len(timeStr) != 4 {
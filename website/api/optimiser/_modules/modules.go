@@ -1,12 +1,16 @@
 package modules
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	client "github.com/nusmodifications/nusmods/website/api/optimiser/_client"
 	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
@@ -15,33 +19,42 @@ import (
 
 // GetAllModuleSlots gets all module slots that pass conditions in optimiserRequest for all modules.
 // Reduces search space by merging slots of the same lesson type happening at the same day and time and building.
+// Also returns, keyed by lessonKey, the constraints that filtered out every class of a lesson.
 func GetAllModuleSlots(
+	ctx context.Context,
 	optimiserRequest *models.OptimiserRequest,
-) (models.ModuleTimetableMap, models.ModuleDefaultSlotsMap, map[string]struct{}, error) {
-	venues, err := getVenues()
+) (models.ModuleTimetableMap, models.ModuleDefaultSlotsMap, map[string][]string, error) {
+	timetables, err := FetchModuleTimetables(ctx, optimiserRequest)
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	return BuildModuleSlots(timetables, optimiserRequest)
+}
 
-	recordingsMap := make(map[string]struct{}, len(optimiserRequest.Recordings))
-	for _, recording := range optimiserRequest.Recordings {
-		recordingsMap[recording] = struct{}{}
-	}
-
-	freeDaysMap := make(map[string]struct{}, len(optimiserRequest.FreeDays))
-	for _, freeDay := range optimiserRequest.FreeDays {
-		freeDaysMap[freeDay] = struct{}{}
-	}
-
-	moduleSlots := make(models.ModuleTimetableMap)
-
-	// These are default or backup slots for the partial timetable so that we can display some random slot for unallocated lessons
-	defaultSlots := make(models.ModuleDefaultSlotsMap)
-	for _, module := range optimiserRequest.Modules {
+// FetchModuleTimetables fetches the semester's raw timetable of every module and optional module
+// in optimiserRequest, keyed by module as given in the request, with the weeks of each slot parsed. Keeping these
+// separate from BuildModuleSlots lets the solver rebuild the search space for a modified request
+// without fetching the module data again.
+//
+// The fetches are abandoned once ctx is done, e.g. when the request's timeLimitMs passes,
+// failing with a 504 error since there is no timetable to return yet.
+func FetchModuleTimetables(
+	ctx context.Context,
+	optimiserRequest *models.OptimiserRequest,
+) (map[string][]models.ModuleSlot, error) {
+	allModules := slices.Concat(optimiserRequest.Modules, optimiserRequest.OptionalModules)
+	timetables := make(map[string][]models.ModuleSlot, len(allModules))
+	for _, module := range allModules {
 
-		body, err := client.GetModuleData(optimiserRequest.AcadYear, strings.ToUpper(module))
+		body, err := client.GetModuleData(ctx, optimiserRequest.AcadYear, strings.ToUpper(module))
 		if err != nil {
-			return nil, nil, nil, err
+			if ctx.Err() != nil {
+				return nil, &models.SolveError{
+					Code:    http.StatusGatewayTimeout,
+					Message: fmt.Sprintf("deadline passed while fetching module %s", strings.ToUpper(module)),
+				}
+			}
+			return nil, err
 		}
 
 		var moduleData struct {
@@ -52,7 +65,7 @@ func GetAllModuleSlots(
 		}
 		err = json.Unmarshal(body, &moduleData)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
 		}
 
 		// Get the module timetable for the semester
@@ -66,61 +79,307 @@ func GetAllModuleSlots(
 
 		// Parse the weeks
 		for i := range moduleTimetable {
+			parseWeeks(&moduleTimetable[i])
+		}
+
+		timetables[module] = moduleTimetable
+	}
+
+	return timetables, nil
+}
 
-			// Note: if weeks is not a []int, then skip parsing
-			// Currently we are not handling week conflict for non-[]int weeks
-			if _, ok := moduleTimetable[i].Weeks.([]any); !ok {
+// parseWeeks sets the WeeksSet and WeeksString of slot from its weeks, either a list of
+// teaching weeks or a WeekRange. Weeks in neither form are left unparsed, so the slot is
+// assumed to run in every week.
+func parseWeeks(slot *models.ModuleSlot) {
+	switch weeks := slot.Weeks.(type) {
+	case []any:
+		slot.WeeksSet = make(map[int]struct{})
+		weeksStrings := make([]string, 0, len(weeks))
+
+		for _, week := range weeks {
+			weekFloat, ok := week.(float64)
+			if !ok {
 				continue
 			}
+			weekInt := int(weekFloat)
+			slot.WeeksSet[weekInt] = struct{}{}
+			weeksStrings = append(weeksStrings, strconv.Itoa(weekInt))
+		}
+		slot.WeeksString = strings.Join(weeksStrings, ",")
+	case map[string]any:
+		parseWeekRange(slot, weeks)
+	}
+}
+
+// parseWeekRange sets the WeeksSet of slot to the calendar weeks of every date its WeekRange
+// holds a lesson on, and its WeeksString to the dates, so only slots on the same dates are
+// merged. A calendar week is a Monday-to-Sunday week counted from the Unix epoch, so two
+// slots on the same day of the week fall on the same date exactly when they share one. A
+// malformed range is left unparsed.
+func parseWeekRange(slot *models.ModuleSlot, weeks map[string]any) {
+	raw, err := json.Marshal(weeks)
+	if err != nil {
+		return
+	}
+	var weekRange models.WeekRange
+	if err := json.Unmarshal(raw, &weekRange); err != nil {
+		return
+	}
+	dates, ok := weekRangeDates(weekRange)
+	if !ok {
+		return
+	}
 
-			moduleTimetable[i].WeeksSet = make(map[int]struct{})
-			weeks := moduleTimetable[i].Weeks.([]any)
-			weeksStrings := make([]string, 0, len(weeks))
+	slot.WeeksSet = make(map[int]struct{}, len(dates))
+	dateStrings := make([]string, len(dates))
+	for i, date := range dates {
+		// The Unix epoch was a Thursday, so the calendar weeks start 3 days before it
+		slot.WeeksSet[(int(date.Unix()/(24*60*60))+3)/7] = struct{}{}
+		dateStrings[i] = date.Format(time.DateOnly)
+	}
+	slot.WeeksString = strings.Join(dateStrings, ",")
+	slot.CalendarWeeks = true
+}
 
-			for _, week := range weeks {
-				weekFloat, ok := week.(float64)
-				if !ok {
-					continue
-				}
-				weekInt := int(weekFloat)
-				moduleTimetable[i].WeeksSet[weekInt] = struct{}{}
-				weeksStrings = append(weeksStrings, strconv.Itoa(weekInt))
+// weekRangeDates returns the dates weekRange holds a lesson on, ascending: every
+// weekInterval weeks from its start, or only in its weeks if it lists any. It returns false
+// if the start or end is not a date, or the end is before the start.
+func weekRangeDates(weekRange models.WeekRange) ([]time.Time, bool) {
+	start, startErr := time.Parse(time.DateOnly, weekRange.Start)
+	end, endErr := time.Parse(time.DateOnly, weekRange.End)
+	if startErr != nil || endErr != nil || end.Before(start) {
+		return nil, false
+	}
+
+	var dates []time.Time
+	if len(weekRange.Weeks) > 0 {
+		for _, week := range slices.Compact(slices.Sorted(slices.Values(weekRange.Weeks))) {
+			date := start.AddDate(0, 0, 7*(week-1))
+			if week >= 1 && !date.After(end) {
+				dates = append(dates, date)
 			}
-			moduleTimetable[i].WeeksString = strings.Join(weeksStrings, ",")
 		}
+		return dates, true
+	}
 
-		if err := validatePinnedSlots(
-			moduleTimetable,
-			module,
-			optimiserRequest.PinnedMap,
-			recordingsMap,
-			freeDaysMap,
-			optimiserRequest.EarliestMin,
-			optimiserRequest.LatestMin,
-		); err != nil {
+	interval := max(weekRange.WeekInterval, 1)
+	for date := start; !date.After(end); date = date.AddDate(0, 0, 7*interval) {
+		dates = append(dates, date)
+	}
+	return dates, true
+}
+
+// BuildModuleSlots marks the recorded slots of the fetched timetables (see applyRecordings),
+// validates the pinned slots of optimiserRequest against them, then filters and merges every
+// module's slots (see GetAllModuleSlots). The timetables are not modified, so they can be
+// rebuilt with different preferences.
+func BuildModuleSlots(
+	timetables map[string][]models.ModuleSlot,
+	optimiserRequest *models.OptimiserRequest,
+) (models.ModuleTimetableMap, models.ModuleDefaultSlotsMap, map[string][]string, error) {
+	venues, err := getVenues()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := resolveHomeLocation(optimiserRequest, venues); err != nil {
+		return nil, nil, nil, err
+	}
+
+	filters := newSlotFilters(optimiserRequest)
+	optimiserRequest.TeachingWeeks = teachingWeeks(timetables, optimiserRequest.Modules)
+	recorded := recordedClasses(optimiserRequest.RecordingRules)
+
+	moduleSlots := make(models.ModuleTimetableMap)
+	filteredReasons := make(map[string][]string)
+
+	// These are default or backup slots for the partial timetable so that we can display some random slot for unallocated lessons
+	defaultSlots := make(models.ModuleDefaultSlotsMap)
+	for _, module := range optimiserRequest.Modules {
+		// mergeAndFilterModuleSlots fills in the coordinates of each slot in place
+		moduleTimetable := applyRecordings(slices.Clone(timetables[module]), module, optimiserRequest.RecordingRules)
+		setWeekMasks(moduleTimetable, optimiserRequest.TeachingWeeks)
+
+		if err := validatePinnedSlots(moduleTimetable, module, optimiserRequest.PinnedMap, filters); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := validateClassesExist(moduleTimetable, module, optimiserRequest.ClassRanks, "preferred"); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := validateClassesExist(moduleTimetable, module, optimiserRequest.ExcludedMap, "excluded"); err != nil {
+			return nil, nil, nil, err
+		}
+		if err := validateClassesExist(moduleTimetable, module, recorded, "recorded"); err != nil {
 			return nil, nil, nil, err
 		}
 
 		// Store the module slots for the module
-		moduleSlots[module], defaultSlots[module] = mergeAndFilterModuleSlots(
+		var moduleFilteredReasons map[models.LessonType][]string
+		moduleSlots[module], defaultSlots[module], moduleFilteredReasons = mergeAndFilterModuleSlots(
 			moduleTimetable,
 			venues,
 			module,
-			recordingsMap,
-			freeDaysMap,
 			optimiserRequest.PinnedMap,
-			optimiserRequest.EarliestMin,
-			optimiserRequest.LatestMin,
+			filters,
 		)
+		for lessonType, reasons := range moduleFilteredReasons {
+			filteredReasons[strings.ToUpper(module)+"|"+lessonType] = reasons
+		}
+	}
+
+	return moduleSlots, defaultSlots, filteredReasons, nil
+}
+
+// recordedClasses returns the classes named by the rules with a ClassNo (lessonKey -> ClassNo),
+// so they can be validated like pins. A typo in one would otherwise record nothing.
+func recordedClasses(rules []models.RecordingRule) map[string]map[models.ClassNo]struct{} {
+	classes := make(map[string]map[models.ClassNo]struct{})
+	for _, rule := range rules {
+		if rule.ClassNo == "" {
+			continue
+		}
+		if classes[rule.LessonKey] == nil {
+			classes[rule.LessonKey] = make(map[models.ClassNo]struct{})
+		}
+		classes[rule.LessonKey][rule.ClassNo] = struct{}{}
+	}
+	return classes
+}
+
+// applyRecordings marks the slots of a module's timetable that rules record, returning the
+// timetable. A slot recorded in some of its teaching weeks only is split in two: a recorded
+// slot for those weeks and a physical slot for the rest, so the physical constraints only
+// apply to the weeks attended. Slots without a list of teaching weeks, including those held
+// on the dates of a WeekRange, are only recorded by rules without weeks.
+//
+// The slots of timetable may be modified, but not the WeeksSet maps they share with the
+// fetched timetables.
+func applyRecordings(timetable []models.ModuleSlot, module string, rules []models.RecordingRule) []models.ModuleSlot {
+	lessonPrefix := strings.ToUpper(module) + "|"
+	var recordedParts []models.ModuleSlot
+	for i := range timetable {
+		slot := &timetable[i]
+		recordedWeeks := make(map[int]struct{})
+		for _, rule := range rules {
+			if rule.LessonKey != lessonPrefix+slot.LessonType ||
+				(rule.ClassNo != "" && rule.ClassNo != slot.ClassNo) ||
+				(rule.Day != "" && !strings.EqualFold(rule.Day, slot.Day)) {
+				continue
+			}
+			if rule.WeeksSet == nil {
+				slot.Recorded = true
+				break
+			}
+			if slot.WeeksSet == nil || slot.CalendarWeeks {
+				continue
+			}
+			for week := range rule.WeeksSet {
+				if _, ok := slot.WeeksSet[week]; ok {
+					recordedWeeks[week] = struct{}{}
+				}
+			}
+		}
+		if slot.Recorded || len(recordedWeeks) == 0 {
+			continue
+		}
+		if len(recordedWeeks) == len(slot.WeeksSet) {
+			slot.Recorded = true
+			continue
+		}
 
+		physicalWeeks := make(map[int]struct{}, len(slot.WeeksSet)-len(recordedWeeks))
+		for week := range slot.WeeksSet {
+			if _, ok := recordedWeeks[week]; !ok {
+				physicalWeeks[week] = struct{}{}
+			}
+		}
+		recordedPart := *slot
+		recordedPart.Recorded = true
+		setTeachingWeeks(&recordedPart, recordedWeeks)
+		setTeachingWeeks(slot, physicalWeeks)
+		recordedParts = append(recordedParts, recordedPart)
+	}
+	return append(timetable, recordedParts...)
+}
+
+// setTeachingWeeks sets the Weeks, WeeksSet and WeeksString of slot to the teaching weeks in
+// weeks, as parseWeeks would from a list of them.
+func setTeachingWeeks(slot *models.ModuleSlot, weeks map[int]struct{}) {
+	sorted := slices.Sorted(maps.Keys(weeks))
+	weeksStrings := make([]string, len(sorted))
+	for i, week := range sorted {
+		weeksStrings[i] = strconv.Itoa(week)
 	}
+	slot.Weeks = sorted
+	slot.WeeksSet = weeks
+	slot.WeeksString = strings.Join(weeksStrings, ",")
+}
 
-	return moduleSlots, defaultSlots, recordingsMap, nil
+// teachingWeeks returns every week a class of modules runs in, ascending, up to the first
+// MaxTeachingWeeks. Classes without a list of teaching weeks (including those held on the
+// dates of a WeekRange) are assumed to run every week, so they add none.
+func teachingWeeks(timetables map[string][]models.ModuleSlot, modules []string) []int {
+	seen := make(map[int]struct{})
+	for _, module := range modules {
+		for i := range timetables[module] {
+			if timetables[module][i].CalendarWeeks {
+				continue
+			}
+			for week := range timetables[module][i].WeeksSet {
+				seen[week] = struct{}{}
+			}
+		}
+	}
+	weeks := slices.Sorted(maps.Keys(seen))
+	return weeks[:min(len(weeks), constants.MaxTeachingWeeks)]
+}
+
+// setWeekMasks sets the WeekMask of every slot from the teaching weeks it runs in. A slot
+// without a list of teaching weeks is assumed to run in all of them.
+func setWeekMasks(timetable []models.ModuleSlot, weeks []int) {
+	for i := range timetable {
+		slot := &timetable[i]
+		if slot.WeeksSet == nil || slot.CalendarWeeks {
+			slot.WeekMask = ^uint64(0)
+			continue
+		}
+		slot.WeekMask = 0
+		for bit, week := range weeks {
+			if _, ok := slot.WeeksSet[week]; ok {
+				slot.WeekMask |= 1 << bit
+			}
+		}
+	}
+}
+
+// resolveHomeLocation sets the request's HomeCoordinates from its HomeLocation, looking a
+// venue up in venues. A venue that is unknown or has no coordinates is rejected, since the
+// commute cost could not be computed.
+func resolveHomeLocation(optimiserRequest *models.OptimiserRequest, venues map[string]models.Location) error {
+	home := optimiserRequest.HomeLocation
+	switch {
+	case home == nil:
+		return nil
+	case home.Coordinates != nil:
+		optimiserRequest.HomeCoordinates = *home.Coordinates
+		return nil
+	}
+
+	venue, ok := venues[home.Venue]
+	if !ok || isMissingVenueCoordinates(venue.Location) {
+		return &models.SolveError{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("homeLocation venue %s not found", home.Venue),
+		}
+	}
+	optimiserRequest.HomeCoordinates = venue.Location
+	return nil
 }
 
 // validatePinnedSlots ensures every pinned slot for this module references an existing
-// lessonType and classNo in the module's raw timetable, and that a physical (non-recorded)
-// pinned class does not fall on a free day or outside the requested time range. This must
+// lessonType and classNo in the module's raw timetable, and that the physical (non-recorded)
+// slots of a pinned class pass the hard constraints in filters. This must
 // be checked against the raw timetable (not the merged output) because merging drops
 // duplicate-schedule classes by design, and a pin on an existing class must never be
 // rejected as missing.
@@ -128,10 +387,7 @@ func validatePinnedSlots(
 	moduleTimetable []models.ModuleSlot,
 	module string,
 	pinnedMap map[string]models.ClassNo,
-	recordingsMap map[string]struct{},
-	freeDaysMap map[string]struct{},
-	earliestMin int,
-	latestMin int,
+	filters slotFilters,
 ) error {
 	lessonTypeClasses := make(map[models.LessonType]map[models.ClassNo][]models.ModuleSlot)
 	for i := range moduleTimetable {
@@ -171,30 +427,83 @@ func validatePinnedSlots(
 			}
 		}
 
-		// Recorded lessons need no physical attendance, so their pins never conflict
-		// with free days or the time range.
-		if _, isRecorded := recordingsMap[lessonKey]; isRecorded {
-			continue
-		}
+		// Recorded slots need no physical attendance, so they never conflict with the
+		// hard constraints.
 		for i := range slots {
 			slot := &slots[i]
-			if _, ok := freeDaysMap[slot.Day]; ok {
-				return &models.SolveError{
-					Code: http.StatusBadRequest,
-					Message: fmt.Sprintf(
-						"pinned class %s for %s %s falls on free day %s",
-						classNo,
-						strings.ToUpper(module),
-						lessonType,
-						slot.Day,
-					),
-				}
+			if slot.Recorded {
+				continue
+			}
+			reason := filters.filterReason(slot)
+			if reason == "" {
+				continue
+			}
+			var violation string
+			switch {
+			case reason == models.FilterReasonTimeRange:
+				violation = "is outside the allowed time range"
+			case strings.HasPrefix(reason, models.FilterReasonFreeDay+":"):
+				violation = "falls on free day " + slot.Day
+			case strings.HasPrefix(reason, models.FilterReasonAvoidedVenue+":"):
+				violation = "is in avoided venue " + slot.Venue
+			case strings.HasPrefix(reason, models.FilterReasonExcludedWeek+":"):
+				violation = "meets in excluded week " + strings.TrimPrefix(reason, models.FilterReasonExcludedWeek+":")
+			default:
+				violation = "overlaps blackout " + strings.TrimPrefix(reason, models.FilterReasonBlackout+":")
+			}
+			return &models.SolveError{
+				Code: http.StatusBadRequest,
+				Message: fmt.Sprintf(
+					"pinned class %s for %s %s %s",
+					classNo,
+					strings.ToUpper(module),
+					lessonType,
+					violation,
+				),
+			}
+		}
+	}
+	return nil
+}
+
+// validateClassesExist ensures every class in classes (lessonKey -> ClassNo -> any) for this
+// module exists in the module's raw timetable, for the same reason as validatePinnedSlots.
+// kind describes the classes in the error, e.g. "preferred" or "excluded".
+func validateClassesExist[V any](
+	moduleTimetable []models.ModuleSlot,
+	module string,
+	classes map[string]map[models.ClassNo]V,
+	kind string,
+) error {
+	lessonTypeClasses := make(map[models.LessonType]map[models.ClassNo]struct{})
+	for i := range moduleTimetable {
+		slot := &moduleTimetable[i]
+		if lessonTypeClasses[slot.LessonType] == nil {
+			lessonTypeClasses[slot.LessonType] = make(map[models.ClassNo]struct{})
+		}
+		lessonTypeClasses[slot.LessonType][slot.ClassNo] = struct{}{}
+	}
+
+	modulePrefix := strings.ToUpper(module) + "|"
+	for lessonKey, lessonClasses := range classes {
+		if !strings.HasPrefix(lessonKey, modulePrefix) {
+			continue
+		}
+		lessonType := strings.TrimPrefix(lessonKey, modulePrefix)
+		classNos, ok := lessonTypeClasses[lessonType]
+		if !ok {
+			return &models.SolveError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("%s lesson type %s not found for %s", kind, lessonType, strings.ToUpper(module)),
 			}
-			if isSlotOutsideTimeRange(*slot, earliestMin, latestMin) {
+		}
+		for classNo := range lessonClasses {
+			if _, ok := classNos[classNo]; !ok {
 				return &models.SolveError{
 					Code: http.StatusBadRequest,
 					Message: fmt.Sprintf(
-						"pinned class %s for %s %s is outside the allowed time range",
+						"%s class %s not found for %s %s",
+						kind,
 						classNo,
 						strings.ToUpper(module),
 						lessonType,
@@ -206,6 +515,189 @@ func validatePinnedSlots(
 	return nil
 }
 
+// slotFilters holds the request's constraints on physical slots. It is shared by
+// validatePinnedSlots and mergeAndFilterModuleSlots, so a pin is accepted exactly when the
+// filter would keep its class.
+type slotFilters struct {
+	freeDays    map[string]struct{}
+	earliestMin [constants.DaysPerWeek]int // Per day (Mon–Sat), see OptimiserRequest.DayEarliestMin
+	latestMin   [constants.DaysPerWeek]int
+	blackouts   []models.Blackout
+
+	preferredVenues         map[string]struct{} // Upper-cased venues and buildings
+	avoidedVenues           map[string]struct{}
+	avoidVenuesHard         bool
+	avoidedVenuePenalty     float64
+	unpreferredVenuePenalty float64
+
+	classRanks            map[string]map[models.ClassNo]int // See OptimiserRequest.ClassRanks
+	restrictToPreferences bool
+	classRankPenalty      float64
+	excludedClasses       map[string]map[models.ClassNo]struct{} // See OptimiserRequest.ExcludedMap
+
+	excludedWeeks       []int // Ascending, without duplicates
+	excludedWeeksSoft   bool
+	excludedWeekPenalty float64
+}
+
+// newSlotFilters collects the slot constraints of optimiserRequest.
+func newSlotFilters(optimiserRequest *models.OptimiserRequest) slotFilters {
+	freeDaysMap := make(map[string]struct{}, len(optimiserRequest.FreeDays))
+	for _, freeDay := range optimiserRequest.FreeDays {
+		freeDaysMap[freeDay] = struct{}{}
+	}
+	return slotFilters{
+		freeDays:    freeDaysMap,
+		earliestMin: optimiserRequest.DayEarliestMin,
+		latestMin:   optimiserRequest.DayLatestMin,
+		blackouts:   optimiserRequest.Blackouts,
+
+		preferredVenues:         venueSet(optimiserRequest.PreferredVenues),
+		avoidedVenues:           venueSet(optimiserRequest.AvoidedVenues),
+		avoidVenuesHard:         optimiserRequest.AvoidVenuesHard,
+		avoidedVenuePenalty:     optimiserRequest.ScoringWeights.AvoidedVenuePenalty,
+		unpreferredVenuePenalty: optimiserRequest.ScoringWeights.UnpreferredVenuePenalty,
+
+		classRanks:            optimiserRequest.ClassRanks,
+		restrictToPreferences: optimiserRequest.RestrictToPreferences,
+		classRankPenalty:      optimiserRequest.ScoringWeights.ClassRankPenalty,
+		excludedClasses:       optimiserRequest.ExcludedMap,
+
+		excludedWeeks:       slices.Compact(slices.Sorted(slices.Values(optimiserRequest.ExcludedWeeks))),
+		excludedWeeksSoft:   optimiserRequest.ExcludedWeeksSoft,
+		excludedWeekPenalty: optimiserRequest.ScoringWeights.ExcludedWeekPenalty,
+	}
+}
+
+// excludedWeeksMet returns the first excluded week the slot meets in and how many it meets
+// in. A slot without a list of teaching weeks, including one on the dates of a WeekRange,
+// is assumed to meet in every excluded week, as with blackouts.
+func (f slotFilters) excludedWeeksMet(slot *models.ModuleSlot) (int, int) {
+	if len(f.excludedWeeks) == 0 {
+		return 0, 0
+	}
+	if slot.WeeksSet == nil || slot.CalendarWeeks {
+		return f.excludedWeeks[0], len(f.excludedWeeks)
+	}
+	first, count := 0, 0
+	for _, week := range f.excludedWeeks {
+		if _, ok := slot.WeeksSet[week]; ok {
+			if count == 0 {
+				first = week
+			}
+			count++
+		}
+	}
+	return first, count
+}
+
+// rankPenalty returns the penalty of a class for its rank in the lesson's classPreferences,
+// ClassRankPenalty per rank below the first choice, with unlisted classes ranked after every
+// listed one. It also reports whether the class is allowed at all, which it is not if it is
+// unlisted and the request sets restrictToPreferences. A lesson without preferences allows
+// every class at no penalty.
+func (f slotFilters) rankPenalty(lessonKey string, classNo models.ClassNo) (float64, bool) {
+	ranks, ok := f.classRanks[lessonKey]
+	if !ok {
+		return 0, true
+	}
+	rank, listed := ranks[classNo]
+	if !listed {
+		if f.restrictToPreferences {
+			return 0, false
+		}
+		rank = len(ranks)
+	}
+	return f.classRankPenalty * float64(rank), true
+}
+
+// venueSet upper-cases a list of venues and buildings into a set.
+func venueSet(venues []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(venues))
+	for _, venue := range venues {
+		set[strings.ToUpper(venue)] = struct{}{}
+	}
+	return set
+}
+
+// matchVenue returns the entry of venues that the slot's venue matches, either the venue
+// itself or its building (see extractBuildingName), or "" if none does. E-Venues and
+// slots without a venue never match, since they have no building.
+func matchVenue(slot *models.ModuleSlot, venues map[string]struct{}) string {
+	if _, ok := constants.EVenues[slot.Venue]; ok || slot.Venue == "" || len(venues) == 0 {
+		return ""
+	}
+	venue := strings.ToUpper(slot.Venue)
+	if _, ok := venues[venue]; ok {
+		return venue
+	}
+	if building := extractBuildingName(venue); building != venue {
+		if _, ok := venues[building]; ok {
+			return building
+		}
+	}
+	return ""
+}
+
+// filterReason returns why a hard constraint removes the physical slot (one of the
+// models.FilterReason values, with its detail appended), or "" if the slot is allowed.
+func (f slotFilters) filterReason(slot *models.ModuleSlot) string {
+	if _, ok := f.freeDays[slot.Day]; ok {
+		return models.FilterReasonFreeDay + ":" + slot.Day
+	}
+	// A slot on an invalid day is dropped when its fields are parsed, not here
+	if d, ok := models.DayIndex(slot.Day); ok && isSlotOutsideTimeRange(*slot, f.earliestMin[d], f.latestMin[d]) {
+		return models.FilterReasonTimeRange
+	}
+	for i := range f.blackouts {
+		if !f.blackouts[i].Soft && isSlotInBlackout(*slot, f.blackouts[i]) {
+			return models.FilterReasonBlackout + ":" + f.blackouts[i].String()
+		}
+	}
+	if f.avoidVenuesHard {
+		if venue := matchVenue(slot, f.avoidedVenues); venue != "" {
+			return models.FilterReasonAvoidedVenue + ":" + venue
+		}
+	}
+	if !f.excludedWeeksSoft {
+		if week, count := f.excludedWeeksMet(slot); count > 0 {
+			return models.FilterReasonExcludedWeek + ":" + strconv.Itoa(week)
+		}
+	}
+	return ""
+}
+
+// softPenalty returns the total penalty of the soft blackouts the physical slot overlaps, of
+// its venue (AvoidedVenuePenalty in a soft avoided venue, UnpreferredVenuePenalty outside the
+// preferred venues) and of its weeks (ExcludedWeekPenalty per soft excluded week it meets
+// in). E-Venues and slots without a venue are never penalised for their venue.
+func (f slotFilters) softPenalty(slot *models.ModuleSlot) float64 {
+	var penalty float64
+	if f.excludedWeeksSoft {
+		_, count := f.excludedWeeksMet(slot)
+		penalty += f.excludedWeekPenalty * float64(count)
+	}
+	if !f.avoidVenuesHard && matchVenue(slot, f.avoidedVenues) != "" {
+		penalty += f.avoidedVenuePenalty
+	}
+	_, isEVenue := constants.EVenues[slot.Venue]
+	if len(f.preferredVenues) > 0 && !isEVenue && slot.Venue != "" && matchVenue(slot, f.preferredVenues) == "" {
+		penalty += f.unpreferredVenuePenalty
+	}
+	for i := range f.blackouts {
+		blackout := &f.blackouts[i]
+		if !blackout.Soft || !isSlotInBlackout(*slot, *blackout) {
+			continue
+		}
+		if blackout.Penalty > 0 {
+			penalty += blackout.Penalty
+		} else {
+			penalty += constants.BlackoutPenalty
+		}
+	}
+	return penalty
+}
+
 // Gets all venue information from venues.json
 func getVenues() (map[string]models.Location, error) {
 	venues := make(map[string]models.Location)
@@ -217,21 +709,28 @@ func getVenues() (map[string]models.Location, error) {
 	return venues, nil
 }
 
+// mergeAndFilterModuleSlots groups a module's slots into classes, drops the classes that
+// violate a hard constraint and merges classes with identical schedules. It also returns,
+// for each lesson type left with no class, the distinct filter reasons (see
+// slotFilters.filterReason) that removed its classes. Physical slots in a soft blackout are
+// kept with the blackout's penalty in their Penalty field.
 func mergeAndFilterModuleSlots(
 	timetable []models.ModuleSlot,
 	venues map[string]models.Location,
 	module string,
-	recordingsMap map[string]struct{},
-	freeDaysMap map[string]struct{},
 	pinnedMap map[string]models.ClassNo,
-	earliestMin int,
-	latestMin int,
-) (map[models.LessonType]map[models.ClassNo][]models.ModuleSlot, map[models.LessonType][]models.ModuleSlot) {
+	filters slotFilters,
+) (
+	map[models.LessonType]map[models.ClassNo][]models.ModuleSlot,
+	map[models.LessonType][]models.ModuleSlot,
+	map[models.LessonType][]string,
+) {
 
 	// We group by classNo because some slots come as a pair, ie you have to attend both slots to complete the lesson
 	// Key: "lessonType|classNo", Value: []ModuleSlot
 
-	defaultSlots := make(map[models.LessonType][]models.ModuleSlot) // Lesson Type -> []Module Slot
+	defaultSlots := make(map[models.LessonType][]models.ModuleSlot)     // Lesson Type -> []Module Slot
+	excludedDefaults := make(map[models.LessonType][]models.ModuleSlot) // Used only if every class is excluded
 	classGroups := make(map[string][]models.ModuleSlot)
 	for i := range timetable {
 		slot := &timetable[i]
@@ -250,13 +749,13 @@ func mergeAndFilterModuleSlots(
 	// Now validate each classNo group, ie all the lessons for that slot must pass the conditions. For example,
 	// MA1521 has 2 Lectures per week, so it must pass the conditions for both lectures.
 	validClassGroups := make(map[string][]models.ModuleSlot)
+	lessonTypeFilterReasons := make(map[models.LessonType]map[string]struct{})
 
 	for groupKey, slots := range classGroups {
 		parts := strings.SplitN(groupKey, "|", 2)
 		lessonType := parts[0]
 		classNo := parts[1]
 		lessonKey := strings.ToUpper(module) + "|" + lessonType
-		_, isRecorded := recordingsMap[lessonKey]
 
 		// The user fixed this lesson to a specific class; drop all other classes so the
 		// solver is forced to pick the pinned one. This must happen here, before the
@@ -265,36 +764,67 @@ func mergeAndFilterModuleSlots(
 		if isPinned && classNo != pinnedClassNo {
 			continue
 		}
+		// Likewise, restrictToPreferences drops the classes missing from classPreferences
+		rankPenalty, isAllowed := filters.rankPenalty(lessonKey, classNo)
+		if !isAllowed {
+			continue
+		}
 
-		allValid := true
 		// The pinned class also becomes the default/backup slot, so that the default
-		// shareable link respects the pin (map iteration order is random otherwise)
-		if defaultSlots[lessonType] == nil || isPinned {
+		// shareable link respects the pin (map iteration order is random otherwise). An
+		// excluded class only becomes the default if every class of the lesson is excluded,
+		// so it cannot survive in the default shareable link of an unassigned lesson either.
+		_, isExcluded := filters.excludedClasses[lessonKey][classNo]
+		if isExcluded {
+			if excludedDefaults[lessonType] == nil {
+				excludedDefaults[lessonType] = slots
+			}
+		} else if defaultSlots[lessonType] == nil || isPinned {
 			defaultSlots[lessonType] = slots
 		}
 
-		// Only apply filters to physical lessons. Pinned classes pass these filters by
-		// construction: validatePinnedSlots rejects the request if a physical pin
-		// conflicts with a free day or the time range.
-		if !isRecorded {
+		// Excluded classes are removed whether recorded or not, before the merge below so
+		// that an excluded class cannot survive as the merged twin of another. Otherwise,
+		// only apply filters to physical slots. Pinned classes pass these filters by
+		// construction: validatePinnedSlots rejects the request if a physical pinned slot
+		// conflicts with a free day, the time range, a blackout, an avoided venue or an
+		// excluded week, and parseExcludedSlots if it is excluded.
+		filterReason := ""
+		if isExcluded {
+			filterReason = models.FilterReasonExcluded
+		} else {
 			for i := range slots {
 				slot := &slots[i]
-				// Check free days
-				if _, ok := freeDaysMap[slot.Day]; ok {
-					allValid = false
-					break
+				if slot.Recorded {
+					continue
 				}
-
-				if isSlotOutsideTimeRange(*slot, earliestMin, latestMin) {
-					allValid = false
+				if filterReason = filters.filterReason(slot); filterReason != "" {
 					break
 				}
+				slot.Penalty = filters.softPenalty(slot)
+			}
+			// The rank is a property of the class, so only its first slot carries it, even
+			// when recorded, so a fully recorded class still ranks below the first choice. As
+			// a penalty it is part of the merge key below, so classes of different ranks are
+			// never merged.
+			if filterReason == "" {
+				slots[0].Penalty += rankPenalty
 			}
 		}
 
 		// If all slots in this class are valid, keep the entire class
-		if allValid {
+		if filterReason == "" {
 			validClassGroups[groupKey] = slots
+			continue
+		}
+		if lessonTypeFilterReasons[lessonType] == nil {
+			lessonTypeFilterReasons[lessonType] = make(map[string]struct{})
+		}
+		lessonTypeFilterReasons[lessonType][filterReason] = struct{}{}
+	}
+	for lessonType, slots := range excludedDefaults {
+		if defaultSlots[lessonType] == nil {
+			defaultSlots[lessonType] = slots
 		}
 	}
 
@@ -336,7 +866,8 @@ func mergeAndFilterModuleSlots(
 			if _, ok := constants.EVenues[slot.Venue]; !ok {
 				allEVenues = false
 				buildingName := extractBuildingName(slot.Venue)
-				part := slot.Day + "|" + slot.StartTime + "|" + buildingName + "|" + slot.WeeksString
+				part := slot.Day + "|" + slot.StartTime + "|" + buildingName + "|" + slot.WeeksString +
+					"|" + strconv.FormatFloat(slot.Penalty, 'g', -1, 64) + "|" + strconv.FormatBool(slot.Recorded)
 				combinationParts = append(combinationParts, part)
 			}
 		}
@@ -356,7 +887,20 @@ func mergeAndFilterModuleSlots(
 		mergedTimetable[lessonType][classNo] = slots
 	}
 
-	return mergedTimetable, defaultSlots
+	// Only lesson types with no class left are unassignable; the reasons for the
+	// classes filtered out of the other lesson types are not needed.
+	filteredReasons := make(map[models.LessonType][]string)
+	for lessonType, reasons := range lessonTypeFilterReasons {
+		if _, ok := mergedTimetable[lessonType]; ok {
+			continue
+		}
+		for reason := range reasons {
+			filteredReasons[lessonType] = append(filteredReasons[lessonType], reason)
+		}
+		sort.Strings(filteredReasons[lessonType])
+	}
+
+	return mergedTimetable, defaultSlots, filteredReasons
 }
 
 // isSlotOutsideTimeRange checks if the slot's timing falls outside the specified earliest and latest times.
@@ -369,6 +913,33 @@ func isSlotOutsideTimeRange(slot models.ModuleSlot, earliestMin, latestMin int)
 	return startMin < earliestMin || endMin > latestMin
 }
 
+// isSlotInBlackout checks if the slot overlaps the blackout in time on the same day, in at
+// least one week the blackout applies to. A slot without parsed weeks is assumed to
+// overlap, as in hasConflict.
+func isSlotInBlackout(slot models.ModuleSlot, blackout models.Blackout) bool {
+	if !strings.EqualFold(slot.Day, blackout.Day) {
+		return false
+	}
+	startMin, startErr := models.ParseTimeToMinutes(slot.StartTime)
+	endMin, endErr := models.ParseTimeToMinutes(slot.EndTime)
+	if startErr != nil || endErr != nil {
+		return false // If we can't parse the time, don't filter it out
+	}
+	if startMin >= blackout.EndMin || blackout.StartMin >= endMin {
+		return false
+	}
+	// Blackout weeks are teaching weeks, which cannot be told apart from a slot's dates
+	if blackout.WeeksSet == nil || slot.WeeksSet == nil || slot.CalendarWeeks {
+		return true
+	}
+	for week := range slot.WeeksSet {
+		if _, ok := blackout.WeeksSet[week]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // extractBuildingName extracts the building name from the venue name.
 // Returns the part before '-' or the whole key if '-' is absent.
 func extractBuildingName(key string) string {
@@ -0,0 +1,165 @@
+package modules
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// TestFetchModuleTimetables_DeadlinePassed fetches with a context that is already done, which
+// must fail with a 504 error before reaching the module API.
+func TestFetchModuleTimetables_DeadlinePassed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := models.OptimiserRequest{Modules: []string{"CS2040S"}, AcadYear: "2024-2025", AcadSem: 1}
+
+	_, err := FetchModuleTimetables(ctx, &req)
+	var solveErr *models.SolveError
+	if !errors.As(err, &solveErr) || solveErr.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected a 504 error, got %v", err)
+	}
+}
+
+// TestWeekRangeDates verifies the dates a WeekRange holds lessons on, with a weekInterval, a
+// list of weeks, and a malformed range.
+func TestWeekRangeDates(t *testing.T) {
+	tests := []struct {
+		name      string
+		weekRange models.WeekRange
+		want      []string
+		ok        bool
+	}{
+		{
+			name:      "every week",
+			weekRange: models.WeekRange{Start: "2025-01-13", End: "2025-01-27"},
+			want:      []string{"2025-01-13", "2025-01-20", "2025-01-27"},
+			ok:        true,
+		},
+		{
+			name:      "every other week",
+			weekRange: models.WeekRange{Start: "2025-01-13", End: "2025-02-12", WeekInterval: 2},
+			want:      []string{"2025-01-13", "2025-01-27", "2025-02-10"},
+			ok:        true,
+		},
+		{
+			name:      "listed weeks",
+			weekRange: models.WeekRange{Start: "2025-01-13", End: "2025-02-03", Weeks: []int{4, 1, 1, 0, 5}},
+			want:      []string{"2025-01-13", "2025-02-03"},
+			ok:        true,
+		},
+		{
+			name:      "end before start",
+			weekRange: models.WeekRange{Start: "2025-01-13", End: "2025-01-06"},
+		},
+		{
+			name:      "not a date",
+			weekRange: models.WeekRange{Start: "13/01/2025", End: "2025-01-27"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dates, ok := weekRangeDates(tt.weekRange)
+
+			got := make([]string, len(dates))
+			for i, date := range dates {
+				got[i] = date.Format(time.DateOnly)
+			}
+			if ok != tt.ok || !slices.Equal(got, tt.want) {
+				t.Errorf("Expected %v (%v), got %v (%v)", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+// TestParseWeeks_WeekRange verifies that a WeekRange is parsed into the calendar weeks of its
+// dates, so that lessons on different dates hold disjoint weeks.
+func TestParseWeeks_WeekRange(t *testing.T) {
+	parse := func(weeks string) models.ModuleSlot {
+		var slot models.ModuleSlot
+		if err := json.Unmarshal([]byte(`{"weeks": `+weeks+`}`), &slot); err != nil {
+			t.Fatal(err)
+		}
+		parseWeeks(&slot)
+		return slot
+	}
+
+	odd := parse(`{"start": "2025-01-13", "end": "2025-02-10", "weekInterval": 2}`)
+	even := parse(`{"start": "2025-01-20", "end": "2025-02-10", "weekInterval": 2}`)
+	third := parse(`{"start": "2025-01-27", "end": "2025-01-27"}`)
+	malformed := parse(`{"start": "2025-01-27", "end": "2025-01-20"}`)
+
+	if !odd.CalendarWeeks || odd.WeeksString != "2025-01-13,2025-01-27,2025-02-10" || len(odd.WeeksSet) != 3 {
+		t.Fatalf("Expected 3 calendar weeks on the listed dates, got %v (%q)", odd.WeeksSet, odd.WeeksString)
+	}
+	for week := range even.WeeksSet {
+		if _, ok := odd.WeeksSet[week]; ok {
+			t.Errorf("Expected alternate weeks to share no calendar week, both hold %d", week)
+		}
+	}
+	if _, ok := odd.WeeksSet[slices.Collect(maps.Keys(third.WeeksSet))[0]]; !ok {
+		t.Errorf("Expected the week of 2025-01-27 in %v, got %v", odd.WeeksSet, third.WeeksSet)
+	}
+	if malformed.WeeksSet != nil || malformed.CalendarWeeks {
+		t.Errorf("Expected a malformed range left unparsed, got %v", malformed.WeeksSet)
+	}
+}
+
+// TestBuildModuleSlots_ExcludedClassNotDefault leaves a tutorial unassignable, with one class
+// excluded and the other on a free day: the default slot, used for the default shareable
+// link, must never be the excluded class unless every class is excluded.
+func TestBuildModuleSlots_ExcludedClassNotDefault(t *testing.T) {
+	timetables := map[string][]models.ModuleSlot{"MOD0": {
+		{ClassNo: "01", Day: "Monday", StartTime: "1000", EndTime: "1100", LessonType: "Tutorial", Venue: "COM1-0201"},
+		{ClassNo: "02", Day: "Tuesday", StartTime: "1000", EndTime: "1100", LessonType: "Tutorial", Venue: "COM1-0201"},
+	}}
+	tests := []struct {
+		name     string
+		excluded []string
+		want     []models.ClassNo
+	}{
+		{name: "one class excluded", excluded: []string{"MOD0|Tutorial|01"}, want: []models.ClassNo{"02"}},
+		{
+			name:     "every class excluded",
+			excluded: []string{"MOD0|Tutorial|01", "MOD0|Tutorial|02"},
+			want:     []models.ClassNo{"01", "02"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The classes are visited in map order, so repeat to catch an excluded default
+			for range 20 {
+				req := models.OptimiserRequest{
+					Modules:       []string{"MOD0"},
+					FreeDays:      []string{"Tuesday"},
+					ExcludedSlots: tt.excluded,
+					EarliestTime:  "0800",
+					LatestTime:    "2000",
+					LunchStart:    "1200",
+					LunchEnd:      "1400",
+				}
+				if err := req.ParseOptimiserRequestFields(); err != nil {
+					t.Fatal(err)
+				}
+
+				moduleSlots, defaultSlots, _, err := BuildModuleSlots(timetables, &req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(moduleSlots["MOD0"]["Tutorial"]) != 0 {
+					t.Fatalf("Expected no assignable tutorial, got %v", moduleSlots["MOD0"]["Tutorial"])
+				}
+				slots := defaultSlots["MOD0"]["Tutorial"]
+				if len(slots) == 0 || !slices.Contains(tt.want, slots[0].ClassNo) {
+					t.Fatalf("Expected a default tutorial in %v, got %v", tt.want, slots)
+				}
+			}
+		})
+	}
+}
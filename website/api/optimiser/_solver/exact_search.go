@@ -0,0 +1,161 @@
+package solver
+
+import (
+	"context"
+	"sort"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// estimateSearchSpace returns the number of complete timetables the exact search would have
+// to consider without pruning, i.e. the product of every lesson's class options. Counting
+// stops as soon as the product exceeds limit, so the result is only exact up to limit.
+func estimateSearchSpace(lessons []string, lessonToSlots map[string][][]models.ModuleSlot, limit int) int {
+	space := 1
+	for _, lessonKey := range lessons {
+		options := len(lessonToSlots[lessonKey])
+		if options == 0 {
+			continue
+		}
+		space *= options
+		if space > limit {
+			return space
+		}
+	}
+	return space
+}
+
+// exactSearcher holds the state shared across the recursive branch-and-bound search.
+type exactSearcher struct {
+	ctx              context.Context
+	lessons          []string
+	validGroups      [][][]models.ModuleSlot // validGroups[i] are the class options of lessons[i]
+	openDays         [][constants.DaysPerWeek]bool
+	optimiserRequest models.OptimiserRequest
+	poolSize         int
+	pool             []models.TimetableState // Best complete timetables found so far, sorted by score
+	cancelled        bool
+}
+
+// exactSearch finds the best complete timetables with a depth-first branch-and-bound search
+// over every class option of every lesson (no branching factor or beam width cap).
+//
+// A branch is pruned once lowerBoundScore shows that no completion of it can beat the worst
+// timetable in the pool. With poolSize 1 the pool is just the incumbent; a larger pool keeps
+// the poolSize best timetables so that alternatives can be picked from them.
+//
+// Returns the pool sorted by score, and whether the search ran to completion, in which case
+// pool[0] is proven to be the lowest-scoring timetable that assigns every lesson. The pool
+// is empty if no such timetable exists, or if ctx was done before one was found.
+func exactSearch(
+	ctx context.Context,
+	lessons []string,
+	lessonToSlots map[string][][]models.ModuleSlot,
+	poolSize int,
+	optimiserRequest models.OptimiserRequest,
+) ([]models.TimetableState, bool) {
+	searcher := &exactSearcher{
+		ctx:              ctx,
+		lessons:          lessons,
+		validGroups:      make([][][]models.ModuleSlot, len(lessons)),
+		openDays:         make([][constants.DaysPerWeek]bool, len(lessons)+1),
+		optimiserRequest: optimiserRequest,
+		poolSize:         max(poolSize, 1),
+	}
+	for i, lessonKey := range lessons {
+		searcher.validGroups[i] = filterValidGroups(lessonToSlots[lessonKey], len(lessonToSlots[lessonKey]))
+		// A lesson with no class options can never be assigned, so there is nothing to search
+		if len(searcher.validGroups[i]) == 0 {
+			return nil, true
+		}
+	}
+
+	// openDays[i] marks the days that lessons[i:] can still add slots to
+	for i := len(lessons) - 1; i >= 0; i-- {
+		searcher.openDays[i] = searcher.openDays[i+1]
+		for _, group := range searcher.validGroups[i] {
+			for _, slot := range group {
+				searcher.openDays[i][slot.DayIndex] = true
+			}
+		}
+	}
+
+	searcher.search(0, newEmptyState(searcher.optimiserRequest))
+	return searcher.pool, !searcher.cancelled
+}
+
+// search extends state, which has lessons[:depth] assigned, with every non-conflicting class
+// of lessons[depth], visiting the most promising children first so that a good incumbent is
+// found early and prunes more of the tree.
+func (s *exactSearcher) search(depth int, state models.TimetableState) {
+	if s.ctx.Err() != nil {
+		s.cancelled = true
+		return
+	}
+
+	if depth == len(s.lessons) {
+		state.Score = scoreTimetableState(state, s.optimiserRequest)
+		s.addToPool(state)
+		return
+	}
+
+	if len(s.pool) == s.poolSize &&
+		lowerBoundScore(state, s.openDays[depth], s.optimiserRequest) >= s.pool[len(s.pool)-1].Score {
+		return
+	}
+
+	lessonKey := s.lessons[depth]
+	children := make([]models.TimetableState, 0, len(s.validGroups[depth]))
+	for _, group := range s.validGroups[depth] {
+		if !canAssign(state, group, s.optimiserRequest) {
+			continue
+		}
+		child := assignGroup(state, lessonKey, group, s.optimiserRequest.ScoringWeights)
+		child.Score = scoreTimetableState(child, s.optimiserRequest)
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Score < children[j].Score
+	})
+
+	for _, child := range children {
+		s.search(depth+1, child)
+		if s.cancelled {
+			return
+		}
+	}
+}
+
+// addToPool inserts a complete timetable into the score-sorted pool, dropping the worst
+// timetable if the pool is over capacity.
+func (s *exactSearcher) addToPool(state models.TimetableState) {
+	idx := sort.Search(len(s.pool), func(i int) bool {
+		return s.pool[i].Score > state.Score
+	})
+	if idx == s.poolSize {
+		return
+	}
+	s.pool = append(s.pool, models.TimetableState{})
+	copy(s.pool[idx+1:], s.pool[idx:])
+	s.pool[idx] = state
+	if len(s.pool) > s.poolSize {
+		s.pool = s.pool[:s.poolSize]
+	}
+}
+
+// lowerBoundScore returns an admissible lower bound on the score of every complete
+// timetable that extends state, where openDays marks the days the remaining lessons can
+// still add slots to: the sum of every scorer's LowerBound.
+func lowerBoundScore(
+	state models.TimetableState,
+	openDays [constants.DaysPerWeek]bool,
+	optimiserRequest models.OptimiserRequest,
+) float64 {
+	in := newScoreInput(state, optimiserRequest)
+	var bound float64
+	for _, scorer := range scorers {
+		bound += scorer.LowerBound(in, openDays)
+	}
+	return bound
+}
@@ -0,0 +1,162 @@
+package solver
+
+import (
+	"fmt"
+	"net/http"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// canAssign reports whether group can be added to state without breaking a hard constraint
+// that depends on the rest of the timetable: it must not clash with an assigned slot
+// (hasConflict), must not take a day over a hard maxHoursPerDay, and must leave enough time
+// to walk to and from the neighbouring lessons (lacksTravelTime). Every search (beam, exact
+// and local) checks candidates with canAssign, so a state that breaks one is never created
+// and its subtree is pruned at once.
+func canAssign(
+	state models.TimetableState,
+	group []models.ModuleSlot,
+	optimiserRequest models.OptimiserRequest,
+) bool {
+	return !hasConflict(state, group) &&
+		!exceedsDailyHours(state, group, optimiserRequest) &&
+		!lacksTravelTime(state, group, optimiserRequest)
+}
+
+// exceedsDailyHours checks if adding group would take the physical hours of any of its days
+// over a hard maxHoursPerDay. A soft cap is scored by dailyHoursScorer instead.
+func exceedsDailyHours(
+	state models.TimetableState,
+	group []models.ModuleSlot,
+	optimiserRequest models.OptimiserRequest,
+) bool {
+	if optimiserRequest.MaxHoursPerDay <= 0 || optimiserRequest.MaxHoursPerDaySoft {
+		return false
+	}
+
+	var addedMinutes [constants.DaysPerWeek]int
+	for i := range group {
+		if !group[i].Recorded {
+			addedMinutes[group[i].DayIndex] += group[i].EndMin - group[i].StartMin
+		}
+	}
+	for d, minutes := range addedMinutes {
+		if minutes > 0 && physicalMinutes(state.DaySlots[d])+minutes > maxMinutesPerDay(optimiserRequest) {
+			return true
+		}
+	}
+	return false
+}
+
+// lacksTravelTime checks if a physical slot of group would be too close to another physical
+// slot of the same day, in state or in group, to walk between their venues at walkingSpeed
+// (see travelShortfall). A soft travel time is scored by travelTimeScorer instead.
+func lacksTravelTime(
+	state models.TimetableState,
+	group []models.ModuleSlot,
+	optimiserRequest models.OptimiserRequest,
+) bool {
+	if optimiserRequest.WalkingSpeed <= 0 || optimiserRequest.TravelTimeSoft {
+		return false
+	}
+
+	for i := range group {
+		if group[i].Recorded {
+			continue
+		}
+		for _, oldSlot := range state.DaySlots[group[i].DayIndex] {
+			if !oldSlot.Recorded && travelShortfall(oldSlot, group[i], optimiserRequest.WalkingSpeed) > 0 {
+				return true
+			}
+		}
+		for j := range i {
+			if !group[j].Recorded && group[j].DayIndex == group[i].DayIndex &&
+				travelShortfall(group[j], group[i], optimiserRequest.WalkingSpeed) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// travelShortfall returns how many minutes short the break between two non-overlapping slots
+// of a day is of the time needed to walk between their venues at walkingSpeed metres per
+// minute. It is 0 if the break is long enough, if the slots never fall in the same week, or
+// if either venue has no coordinates, since the distance is then unknown (the distance scorer
+// penalises unknown venues already).
+func travelShortfall(a models.ModuleSlot, b models.ModuleSlot, walkingSpeed float64) float64 {
+	if b.StartMin < a.StartMin {
+		a, b = b, a
+	}
+	breakMinutes := float64(b.StartMin - a.EndMin)
+	if breakMinutes < 0 || isInvalidCoordinates(a.Coordinates) || isInvalidCoordinates(b.Coordinates) ||
+		!sharesWeek(a, b) {
+		return 0
+	}
+	travelMinutes := distanceKm(a.Coordinates, b.Coordinates) * 1000 / walkingSpeed
+	return max(travelMinutes-breakMinutes, 0)
+}
+
+// physicalMinutes returns the total length of the day's physical (non-recorded) slots.
+func physicalMinutes(daySlots []models.ModuleSlot) int {
+	minutes := 0
+	for i := range daySlots {
+		if !daySlots[i].Recorded {
+			minutes += daySlots[i].EndMin - daySlots[i].StartMin
+		}
+	}
+	return minutes
+}
+
+// physicalHoursPerDay returns the physical contact hours of each day of state.
+func physicalHoursPerDay(state models.TimetableState) [constants.DaysPerWeek]float64 {
+	var hours [constants.DaysPerWeek]float64
+	for d := range hours {
+		hours[d] = float64(physicalMinutes(state.DaySlots[d])) / 60
+	}
+	return hours
+}
+
+// maxMinutesPerDay returns maxHoursPerDay in minutes.
+func maxMinutesPerDay(optimiserRequest models.OptimiserRequest) int {
+	return int(optimiserRequest.MaxHoursPerDay * 60)
+}
+
+// validatePinnedDailyHours rejects a request whose physical pinned slots alone take a day
+// over a hard maxHoursPerDay, since no timetable could then keep every pin.
+func validatePinnedDailyHours(
+	lessonToSlots map[string][][]models.ModuleSlot,
+	optimiserRequest models.OptimiserRequest,
+) error {
+	if optimiserRequest.MaxHoursPerDay <= 0 || optimiserRequest.MaxHoursPerDaySoft {
+		return nil
+	}
+
+	var pinnedMinutes [constants.DaysPerWeek]int
+	for lessonKey := range optimiserRequest.PinnedMap {
+		groups := lessonToSlots[lessonKey]
+		if len(groups) != 1 {
+			continue
+		}
+		for _, slot := range groups[0] {
+			if !slot.Recorded && slot.DayIndex >= 0 && slot.DayIndex < constants.DaysPerWeek {
+				pinnedMinutes[slot.DayIndex] += slot.EndMin - slot.StartMin
+			}
+		}
+	}
+	for d, minutes := range pinnedMinutes {
+		if minutes > maxMinutesPerDay(optimiserRequest) {
+			return &models.SolveError{
+				Code: http.StatusBadRequest,
+				Message: fmt.Sprintf(
+					"pinned classes on %s take %.1f hours, over maxHoursPerDay %v",
+					models.DayName(d),
+					float64(minutes)/60,
+					optimiserRequest.MaxHoursPerDay,
+				),
+			}
+		}
+	}
+	return nil
+}
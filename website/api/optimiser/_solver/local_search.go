@@ -0,0 +1,226 @@
+package solver
+
+import (
+	"context"
+	"time"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// localSearch holds the state shared by the moves of a refinement pass.
+type localSearch struct {
+	ctx              context.Context
+	lessons          []string                         // Assigned lessons, in search order
+	unassigned       []string                         // Lessons beam search had to skip, in search order
+	options          map[string][][]models.ModuleSlot // Every valid class option per lesson
+	optimiserRequest models.OptimiserRequest
+	evaluations      int
+}
+
+// refineTimetable improves a complete timetable by hill climbing over class swaps. Beam
+// search commits to the early lessons in MRV order and never revisits them, so its result
+// can often be improved by moving a single class, or two classes at once when one has to
+// make way for the other.
+//
+// Each round tries every single swap (one lesson to another of its classes), and only if
+// none improves the score, every pair swap. The first improving move is applied and the
+// next round starts from the new timetable, until no move improves it or the budget of
+// LocalSearchMaxEvaluations candidate timetables or LocalSearchTimeLimitMs runs out.
+// Moves reuse canAssign, so the refined timetable never breaks a hard constraint.
+//
+// A swap can free the slot that a lesson skipped by beam search needed. Before each round,
+// such a lesson is assigned its best non-clashing class, even if that raises the score,
+// since beam search also always prefers assigning a lesson over skipping it.
+//
+// Returns the refined timetable, how much lower it scores than state, and how many skipped
+// lessons it assigned. The score decrease can be negative when a lesson was assigned.
+func refineTimetable(
+	ctx context.Context,
+	state models.TimetableState,
+	lessons []string,
+	lessonToSlots map[string][][]models.ModuleSlot,
+	optimiserRequest models.OptimiserRequest,
+) (models.TimetableState, float64, int) {
+	ctx, cancel := context.WithTimeout(ctx, constants.LocalSearchTimeLimitMs*time.Millisecond)
+	defer cancel()
+
+	search := &localSearch{
+		ctx:              ctx,
+		options:          make(map[string][][]models.ModuleSlot, len(lessons)),
+		optimiserRequest: optimiserRequest,
+	}
+	for _, lessonKey := range lessons {
+		search.options[lessonKey] = filterValidGroups(lessonToSlots[lessonKey], len(lessonToSlots[lessonKey]))
+		if _, ok := state.Assignments[lessonKey]; ok {
+			search.lessons = append(search.lessons, lessonKey)
+		} else if len(search.options[lessonKey]) > 0 {
+			search.unassigned = append(search.unassigned, lessonKey)
+		}
+	}
+
+	current := state
+	for {
+		next, changed := search.assignFreedLesson(current)
+		if !changed {
+			next, changed = search.improveBySingleSwap(current)
+		}
+		if !changed {
+			next, changed = search.improveByPairSwap(current)
+		}
+		if !changed {
+			break
+		}
+		current = next
+	}
+	return current, state.Score - current.Score, len(current.Assignments) - len(state.Assignments)
+}
+
+// assignFreedLesson assigns the first skipped lesson that now has a non-clashing class to
+// its best such class.
+func (s *localSearch) assignFreedLesson(state models.TimetableState) (models.TimetableState, bool) {
+	for i, lessonKey := range s.unassigned {
+		var best models.TimetableState
+		found := false
+		for _, group := range s.options[lessonKey] {
+			if !s.canAssign(state, group) {
+				continue
+			}
+			candidate := s.assign(state, lessonKey, group)
+			candidate.Score = scoreTimetableState(candidate, s.optimiserRequest)
+			if !found || candidate.Score < best.Score {
+				best, found = candidate, true
+			}
+		}
+		if found {
+			s.unassigned = append(s.unassigned[:i], s.unassigned[i+1:]...)
+			s.lessons = append(s.lessons, lessonKey)
+			return best, true
+		}
+	}
+	return state, false
+}
+
+// exhausted reports whether the refinement budget has run out.
+func (s *localSearch) exhausted() bool {
+	return s.evaluations >= constants.LocalSearchMaxEvaluations || s.ctx.Err() != nil
+}
+
+// improveBySingleSwap returns the first timetable found that moves one lesson to another
+// of its classes and scores lower than state.
+func (s *localSearch) improveBySingleSwap(state models.TimetableState) (models.TimetableState, bool) {
+	for _, lessonKey := range s.lessons {
+		base := s.remove(state, lessonKey)
+		for _, group := range s.options[lessonKey] {
+			if s.exhausted() {
+				return state, false
+			}
+			if group[0].ClassNo == state.Assignments[lessonKey] || !s.canAssign(base, group) {
+				continue
+			}
+			if candidate, ok := s.evaluate(state, s.assign(base, lessonKey, group)); ok {
+				return candidate, true
+			}
+		}
+	}
+	return state, false
+}
+
+// improveByPairSwap returns the first timetable found that moves two lessons to other
+// classes at once and scores lower than state. This finds improvements that need one
+// lesson to vacate a slot for another, which no single swap can reach.
+func (s *localSearch) improveByPairSwap(state models.TimetableState) (models.TimetableState, bool) {
+	for i, firstKey := range s.lessons {
+		withoutFirst := s.remove(state, firstKey)
+		for _, secondKey := range s.lessons[i+1:] {
+			base := s.remove(withoutFirst, secondKey)
+			for _, firstGroup := range s.options[firstKey] {
+				if firstGroup[0].ClassNo == state.Assignments[firstKey] || !s.canAssign(base, firstGroup) {
+					continue
+				}
+				withFirst := s.assign(base, firstKey, firstGroup)
+				for _, secondGroup := range s.options[secondKey] {
+					if s.exhausted() {
+						return state, false
+					}
+					if secondGroup[0].ClassNo == state.Assignments[secondKey] || !s.canAssign(withFirst, secondGroup) {
+						continue
+					}
+					swapped := s.assign(withFirst, secondKey, secondGroup)
+					if candidate, ok := s.evaluate(state, swapped); ok {
+						return candidate, true
+					}
+				}
+			}
+		}
+	}
+	return state, false
+}
+
+// canAssign reports whether group can be added to state, see canAssign.
+func (s *localSearch) canAssign(state models.TimetableState, group []models.ModuleSlot) bool {
+	return canAssign(state, group, s.optimiserRequest)
+}
+
+// assign returns a copy of state with lessonKey assigned to the class in group.
+func (s *localSearch) assign(
+	state models.TimetableState,
+	lessonKey string,
+	group []models.ModuleSlot,
+) models.TimetableState {
+	return assignGroup(state, lessonKey, group, s.optimiserRequest.ScoringWeights)
+}
+
+// remove returns a copy of state without lessonKey's assignment and slots.
+func (s *localSearch) remove(state models.TimetableState, lessonKey string) models.TimetableState {
+	return removeLesson(state, lessonKey, s.optimiserRequest.ScoringWeights)
+}
+
+// evaluate scores candidate and reports whether it improves on state.
+func (s *localSearch) evaluate(
+	state models.TimetableState,
+	candidate models.TimetableState,
+) (models.TimetableState, bool) {
+	s.evaluations++
+	candidate.Score = scoreTimetableState(candidate, s.optimiserRequest)
+	if candidate.Score >= state.Score-constants.LocalSearchMinImprovement {
+		return candidate, false
+	}
+	return candidate, true
+}
+
+// removeLesson returns a copy of state without lessonKey's assignment and slots. Only the
+// days that held one of its slots have their walking distance recalculated. The returned
+// state is not scored.
+func removeLesson(
+	state models.TimetableState,
+	lessonKey string,
+	weights models.ScoringWeights,
+) models.TimetableState {
+	newState := copyState(state)
+	delete(newState.Assignments, lessonKey)
+
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		daySlots := newState.DaySlots[d]
+		kept := daySlots[:0]
+		for _, slot := range daySlots {
+			if slot.LessonKey != lessonKey {
+				kept = append(kept, slot)
+			}
+		}
+		if len(kept) == len(daySlots) {
+			continue
+		}
+
+		newState.DaySlots[d] = kept
+		newState.TotalDistance -= newState.DayDistance[d]
+		newState.DayDistance[d] = calculateDayDistanceScore(kept, weights)
+		newState.TotalDistance += newState.DayDistance[d]
+	}
+
+	// Removing a lesson can leave groups holding the same lessons, so they are rebuilt
+	if newState.WeekGroups != nil {
+		newState.WeekGroups = newWeekGroups(weekGroupsMask(newState.WeekGroups), newState.DaySlots)
+	}
+	return newState
+}
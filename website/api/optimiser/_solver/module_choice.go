@@ -0,0 +1,220 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// moduleCombination is one choice of optionalModules, with the search space of the request
+// that takes them.
+type moduleCombination struct {
+	optional []string
+	req      models.OptimiserRequest // The request with Modules extended by optional
+	space    searchSpace
+	// An admissible lower bound on the score of every complete timetable of the
+	// combination, +Inf if no complete timetable exists (see combinationLowerBound)
+	bound    float64
+	screened bool
+	complete bool    // The screening solve assigned every lesson
+	score    float64 // Score of the screening solve
+}
+
+// moduleChoice is the combination of optionalModules picked for a request.
+type moduleChoice struct {
+	req        models.OptimiserRequest // The request with Modules extended by the chosen modules
+	chosen     []string
+	space      searchSpace
+	timetables map[string][]models.ModuleSlot // The fetched timetables of req.Modules only
+	runnersUp  []models.ModuleCombination
+}
+
+// chooseModules picks which ModuleCount - len(Modules) of the request's optionalModules to
+// take, by screening every combination with a narrower beam (CombinationBeamWidth) and
+// keeping the best: one that assigns every lesson if any does, then the lowest score.
+//
+// Combinations are screened in order of combinationLowerBound. Once enough complete
+// combinations are screened to fill the best and every runner-up, a combination whose bound
+// is no better than the worst of them is skipped without solving, since none of its
+// timetables could be listed. Screening stops once CombinationTimeLimitMs has elapsed or
+// when ctx is done; if no combination was screened by then, the one with the lowest bound
+// is picked.
+//
+// The returned search space is that of the chosen combination, so the caller can solve it
+// in full. The runners-up are the other screened combinations, best first.
+func chooseModules(
+	ctx context.Context,
+	timetables map[string][]models.ModuleSlot,
+	req models.OptimiserRequest,
+) (moduleChoice, error) {
+	k := req.ModuleCount - len(req.Modules)
+	if count := countCombinations(len(req.OptionalModules), k, constants.MaxModuleCombinations); count >
+		constants.MaxModuleCombinations {
+		return moduleChoice{}, &models.SolveError{
+			Code: http.StatusBadRequest,
+			Message: fmt.Sprintf(
+				"choosing %d of %d optionalModules has more than %d combinations",
+				k,
+				len(req.OptionalModules),
+				constants.MaxModuleCombinations,
+			),
+		}
+	}
+
+	var combinations []*moduleCombination
+	for _, optional := range chooseK(req.OptionalModules, k) {
+		combinationReq := req
+		combinationReq.Modules = slices.Concat(req.Modules, optional)
+		space, err := buildSearchSpace(timetables, &combinationReq)
+		if err != nil {
+			return moduleChoice{}, err
+		}
+		combinations = append(combinations, &moduleCombination{
+			optional: optional,
+			req:      combinationReq,
+			space:    space,
+			bound:    combinationLowerBound(space, combinationReq),
+		})
+	}
+	sort.SliceStable(combinations, func(i, j int) bool {
+		return combinations[i].bound < combinations[j].bound
+	})
+
+	screen, cancel := newScreening(
+		ctx,
+		constants.MaxModuleCombinations,
+		constants.CombinationBeamWidth,
+		constants.CombinationTimeLimitMs*time.Millisecond,
+	)
+	defer cancel()
+
+	// Best scores of the complete screened combinations, ascending, kept to the number listed
+	var completeScores []float64
+	for _, combination := range combinations {
+		if len(completeScores) > constants.MaxModuleCombinationSuggestions &&
+			combination.bound >= completeScores[len(completeScores)-1] {
+			continue
+		}
+		if screen.exhausted() {
+			break
+		}
+		state, ok := screen.solve(combination.space, combination.req)
+		if !ok {
+			break
+		}
+		combination.screened = true
+		combination.score = state.Score
+		combination.complete = len(state.Assignments) == countLessons(combination.space.defaultSlots)
+		if combination.complete {
+			i, _ := slices.BinarySearch(completeScores, combination.score)
+			completeScores = slices.Insert(completeScores, i, combination.score)
+			completeScores = completeScores[:min(len(completeScores), constants.MaxModuleCombinationSuggestions+1)]
+		}
+	}
+
+	screened := slices.DeleteFunc(slices.Clone(combinations), func(c *moduleCombination) bool { return !c.screened })
+	sort.SliceStable(screened, func(i, j int) bool {
+		if screened[i].complete != screened[j].complete {
+			return screened[i].complete
+		}
+		return screened[i].score < screened[j].score
+	})
+
+	best := combinations[0]
+	if len(screened) > 0 {
+		best = screened[0]
+	}
+	runnersUp := make([]models.ModuleCombination, 0, constants.MaxModuleCombinationSuggestions)
+	for _, combination := range screened {
+		if combination == best || len(runnersUp) == constants.MaxModuleCombinationSuggestions {
+			continue
+		}
+		runnersUp = append(runnersUp, models.ModuleCombination{
+			Modules:  combination.optional,
+			Score:    combination.score,
+			Complete: combination.complete,
+		})
+	}
+
+	chosenTimetables := make(map[string][]models.ModuleSlot, len(best.req.Modules))
+	for _, module := range best.req.Modules {
+		chosenTimetables[module] = timetables[module]
+	}
+	return moduleChoice{
+		req:        best.req,
+		chosen:     best.optional,
+		space:      best.space,
+		timetables: chosenTimetables,
+		runnersUp:  runnersUp,
+	}, nil
+}
+
+// combinationLowerBound returns an admissible lower bound on the score of every timetable of
+// space that assigns every lesson: the lessons with a single class option (including every
+// pin) are assigned first, since every such timetable contains them, and lowerBoundScore
+// bounds the rest. It is +Inf if a lesson has no class option or the single options clash,
+// since no timetable then assigns every lesson.
+func combinationLowerBound(space searchSpace, req models.OptimiserRequest) float64 {
+	state := newEmptyState(req)
+	var openDays [constants.DaysPerWeek]bool
+	for _, lessonKey := range space.lessons {
+		options := filterValidGroups(space.lessonToSlots[lessonKey], len(space.lessonToSlots[lessonKey]))
+		switch {
+		case len(options) == 0:
+			return math.Inf(1)
+		case len(options) == 1:
+			if !canAssign(state, options[0], req) {
+				return math.Inf(1)
+			}
+			state = assignGroup(state, lessonKey, options[0], req.ScoringWeights)
+		default:
+			for _, group := range options {
+				for _, slot := range group {
+					openDays[slot.DayIndex] = true
+				}
+			}
+		}
+	}
+	return lowerBoundScore(state, openDays, req)
+}
+
+// chooseK returns every k-element subsequence of items, in lexicographic order of indices.
+func chooseK(items []string, k int) [][]string {
+	var result [][]string
+	combination := make([]string, 0, k)
+	var choose func(start int)
+	choose = func(start int) {
+		if len(combination) == k {
+			result = append(result, slices.Clone(combination))
+			return
+		}
+		for i := start; i <= len(items)-(k-len(combination)); i++ {
+			combination = append(combination, items[i])
+			choose(i + 1)
+			combination = combination[:len(combination)-1]
+		}
+	}
+	choose(0)
+	return result
+}
+
+// countCombinations returns n choose k, or any number over limit once it exceeds limit.
+func countCombinations(n int, k int, limit int) int {
+	k = min(k, n-k)
+	count := 1
+	for i := 1; i <= k; i++ {
+		// count becomes (n-k+i) choose i, so the division is always exact
+		count = count * (n - k + i) / i
+		if count > limit {
+			return count
+		}
+	}
+	return count
+}
@@ -0,0 +1,97 @@
+package solver
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// expandBeam expands every state in the beam with every non-conflicting group in
+// validGroups for lessonKey, and scores the resulting states.
+//
+// The beam is split into contiguous shards that are expanded concurrently by up to
+// beamWorkers goroutines. Each worker only appends to its own shard's output, and the
+// outputs are concatenated in shard order, so the result is in exactly the order the
+// sequential loop would produce. Since sort.Slice is deterministic for a given input
+// order, the pruned beam (and therefore the solution) is identical to a single-core run.
+//
+// Returns whether ctx was done before the expansion finished, in which case the returned
+// states are incomplete and must be discarded.
+func expandBeam(
+	ctx context.Context,
+	beam []models.TimetableState,
+	lessonKey string,
+	validGroups [][]models.ModuleSlot,
+	optimiserRequest models.OptimiserRequest,
+) ([]models.TimetableState, bool) {
+	workers := beamWorkers(len(beam))
+	shardSize := (len(beam) + workers - 1) / workers
+	shards := make([][]models.TimetableState, workers)
+	cancelled := make([]bool, workers)
+
+	expandShard := func(worker int) {
+		start := worker * shardSize
+		end := min(start+shardSize, len(beam))
+		if start >= end {
+			return
+		}
+
+		expanded := make([]models.TimetableState, 0, (end-start)*len(validGroups))
+		for _, state := range beam[start:end] {
+			if ctx.Err() != nil {
+				cancelled[worker] = true
+				return
+			}
+
+			// iterate over all pre-filtered slot groups for the current lesson
+			for _, validGroup := range validGroups {
+				if !canAssign(state, validGroup, optimiserRequest) {
+					continue
+				}
+				newState := assignGroup(state, lessonKey, validGroup, optimiserRequest.ScoringWeights)
+				newState.Score = scoreTimetableState(newState, optimiserRequest)
+				expanded = append(expanded, newState)
+			}
+		}
+		shards[worker] = expanded
+	}
+
+	if workers == 1 {
+		expandShard(0)
+	} else {
+		var wg sync.WaitGroup
+		for worker := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				expandShard(worker)
+			}()
+		}
+		wg.Wait()
+	}
+
+	total := 0
+	for worker := range workers {
+		if cancelled[worker] {
+			return nil, true
+		}
+		total += len(shards[worker])
+	}
+	nextBeam := make([]models.TimetableState, 0, total)
+	for _, shard := range shards {
+		nextBeam = append(nextBeam, shard...)
+	}
+	return nextBeam, false
+}
+
+// beamWorkers returns the number of goroutines to expand a beam of beamSize states with:
+// bounded by GOMAXPROCS and MaxBeamWorkers, and low enough that each worker gets at least
+// MinStatesPerBeamWorker states, so that small beams (e.g. the first few lessons) are not
+// slowed down by goroutine overhead.
+func beamWorkers(beamSize int) int {
+	workers := min(runtime.GOMAXPROCS(0), constants.MaxBeamWorkers, beamSize/constants.MinStatesPerBeamWorker)
+	return max(workers, 1)
+}
@@ -0,0 +1,156 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// recordingTrial is the screening solve of a request with some lessons recorded.
+type recordingTrial struct {
+	req      models.OptimiserRequest
+	assigned int // Lessons the screening solve assigned
+	score    float64
+}
+
+// improves reports whether t is a better timetable than other: one that assigns more lessons,
+// or as many with a lower score.
+func (t recordingTrial) improves(other recordingTrial) bool {
+	if t.assigned != other.assigned {
+		return t.assigned > other.assigned
+	}
+	return t.score < other.score-constants.LocalSearchMinImprovement
+}
+
+// recommendRecordings greedily picks up to recommendRecordings (at most
+// MaxRecommendedRecordings) of the request's recording candidates to mark as recorded. Each
+// round solves the request with every remaining candidate recorded in turn, with a narrower
+// beam (RecordingBeamWidth), and keeps the one whose timetable improves the most: the most
+// lessons assigned, e.g. because a free day's lectures are no longer filtered out, then the
+// lowest score. It stops when no candidate improves the timetable, after
+// MaxRecordingEvaluations solves, RecordingTimeLimitMs or when ctx is done; a round cut short
+// keeps the best candidate it solved.
+//
+// It returns the recommendations in the order picked, and req with them added to its
+// recordings, so the caller can rebuild the search space and solve it in full.
+func recommendRecordings(
+	ctx context.Context,
+	timetables map[string][]models.ModuleSlot,
+	req models.OptimiserRequest,
+) ([]models.RecordingRecommendation, models.OptimiserRequest, error) {
+	candidates, err := recordingCandidates(timetables, req)
+	if err != nil {
+		return nil, req, err
+	}
+	screen, cancel := newScreening(
+		ctx,
+		constants.MaxRecordingEvaluations,
+		constants.RecordingBeamWidth,
+		constants.RecordingTimeLimitMs*time.Millisecond,
+	)
+	defer cancel()
+
+	current, ok := solveWithRecordings(screen, timetables, req)
+	if !ok {
+		return nil, req, nil
+	}
+
+	var recommendations []models.RecordingRecommendation
+	for len(recommendations) < min(req.RecommendRecordings, constants.MaxRecommendedRecordings) {
+		bestIndex := -1
+		var best recordingTrial
+		for i, candidate := range candidates {
+			if screen.exhausted() {
+				break
+			}
+			trial, ok := solveWithRecordings(screen, timetables, current.req, candidate)
+			if ok && trial.improves(current) && (bestIndex == -1 || trial.improves(best)) {
+				bestIndex, best = i, trial
+			}
+		}
+		if bestIndex == -1 {
+			break
+		}
+
+		recommendations = append(recommendations, models.RecordingRecommendation{
+			Lesson:        candidates[bestIndex],
+			ScoreGain:     current.score - best.score,
+			LessonsGained: best.assigned - current.assigned,
+		})
+		current = best
+		candidates = slices.Delete(candidates, bestIndex, bestIndex+1)
+	}
+	return recommendations, current.req, nil
+}
+
+// recordingCandidates returns the lessonKeys recommendRecordings may record: the request's
+// recordingCandidates of the modules it takes, or every lecture if it lists none, less the
+// lessons already recorded in full. A candidate lesson type the module does not have is
+// rejected, like a pin on one.
+func recordingCandidates(timetables map[string][]models.ModuleSlot, req models.OptimiserRequest) ([]string, error) {
+	if len(req.RecordingCandidateKeys) == 0 {
+		return unrecordedLectures(timetables, req), nil
+	}
+
+	lessons := make(map[string]struct{})
+	for module, timetable := range timetables {
+		for i := range timetable {
+			lessons[strings.ToUpper(module)+"|"+timetable[i].LessonType] = struct{}{}
+		}
+	}
+	modules := make(map[string]struct{}, len(req.Modules))
+	for _, module := range req.Modules {
+		modules[strings.ToUpper(module)] = struct{}{}
+	}
+	recorded := fullyRecordedLessons(req)
+
+	var candidates []string
+	for _, lessonKey := range req.RecordingCandidateKeys {
+		module, lessonType, _ := strings.Cut(lessonKey, "|")
+		if _, ok := modules[module]; !ok {
+			// An optionalModules candidate that was not chosen
+			continue
+		}
+		if _, ok := lessons[lessonKey]; !ok {
+			return nil, &models.SolveError{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("recording candidate lesson type %s not found for %s", lessonType, module),
+			}
+		}
+		if _, ok := recorded[lessonKey]; !ok {
+			candidates = append(candidates, lessonKey)
+		}
+	}
+	return candidates, nil
+}
+
+// solveWithRecordings adds the lessonKeys in recordings to a copy of req's recordings and
+// solves it as part of screen, reporting whether the solve ran to completion.
+func solveWithRecordings(
+	screen *screening,
+	timetables map[string][]models.ModuleSlot,
+	req models.OptimiserRequest,
+	recordings ...string,
+) (recordingTrial, bool) {
+	trial := req
+	trial.Recordings = slices.Concat(req.Recordings, recordings)
+	if err := trial.ParseRecordings(); err != nil {
+		return recordingTrial{}, false
+	}
+
+	space, err := buildSearchSpace(timetables, &trial)
+	if err != nil {
+		return recordingTrial{}, false
+	}
+	state, ok := screen.solve(space, trial)
+	if !ok {
+		return recordingTrial{}, false
+	}
+	return recordingTrial{req: trial, assigned: len(state.Assignments), score: state.Score}, true
+}
@@ -0,0 +1,373 @@
+package solver
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// relaxationStep is a candidate change to the request.
+type relaxationStep struct {
+	change models.RelaxationChange
+	// How much of a preference the change gives up, e.g. widening the time window by 60
+	// minutes costs twice as much as by 30 minutes
+	cost int
+	// Steps in the same group are alternatives to each other and never combined
+	group string
+	apply func(req *models.OptimiserRequest)
+}
+
+// relaxationResult is a combination of steps that made every lesson assignable.
+type relaxationResult struct {
+	steps []relaxationStep
+	cost  int
+	state models.TimetableState
+	space searchSpace
+	req   models.OptimiserRequest
+}
+
+// hasUnsatisfiableLessons reports whether any lesson was left unassigned by the request's
+// preferences rather than by the deadline or the search.
+func hasUnsatisfiableLessons(unassignedLessons []models.UnassignedLesson) bool {
+	for _, unassigned := range unassignedLessons {
+		if unassigned.Reason != models.UnassignedReasonTimeLimit &&
+			unassigned.Reason != models.UnassignedReasonSearchPruned {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestRelaxations searches for the smallest relaxations of req (drop a free day, widen
+// the time window, mark a lecture as recorded, unpin or include a class, soften a blackout,
+// raise a hard maxHoursPerDay, soften hard travel times, avoided venues or excluded weeks)
+// under which every lesson can be assigned, reusing the already fetched module timetables.
+//
+// Every single step is tried first; pairs of steps are only tried if no single step is
+// enough. Each relaxed request is solved with a narrower beam (RelaxationBeamWidth), and
+// the search stops after MaxRelaxationEvaluations relaxed requests, once
+// RelaxationTimeLimitMs has elapsed or when ctx is done.
+// The returned relaxations are ranked by total cost, then by score.
+func suggestRelaxations(
+	ctx context.Context,
+	timetables map[string][]models.ModuleSlot,
+	req models.OptimiserRequest,
+) []models.Relaxation {
+	steps := relaxationSteps(timetables, req)
+	screen, cancel := newScreening(
+		ctx,
+		constants.MaxRelaxationEvaluations,
+		constants.RelaxationBeamWidth,
+		constants.RelaxationTimeLimitMs*time.Millisecond,
+	)
+	defer cancel()
+	var found []relaxationResult
+
+	try := func(combination []relaxationStep) {
+		if screen.exhausted() {
+			return
+		}
+		if result, ok := solveRelaxed(screen, timetables, req, combination); ok {
+			found = append(found, result)
+		}
+	}
+
+	for i := range steps {
+		try(steps[i : i+1])
+	}
+	if len(found) == 0 {
+		for i := range steps {
+			for j := i + 1; j < len(steps); j++ {
+				if steps[i].group != steps[j].group {
+					try([]relaxationStep{steps[i], steps[j]})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].cost != found[j].cost {
+			return found[i].cost < found[j].cost
+		}
+		return found[i].state.Score < found[j].state.Score
+	})
+	found = found[:min(len(found), constants.MaxRelaxationSuggestions)]
+
+	relaxations := make([]models.Relaxation, 0, len(found))
+	for _, result := range found {
+		changes := make([]models.RelaxationChange, 0, len(result.steps))
+		for _, step := range result.steps {
+			changes = append(changes, step.change)
+		}
+		shareableLink, _ := FillDefaultsAndGenerateShareableLinks(
+			result.state.Assignments,
+			result.space.defaultSlots,
+			result.req,
+		)
+		relaxations = append(relaxations, models.Relaxation{
+			Changes:       changes,
+			Score:         result.state.Score,
+			ShareableLink: shareableLink,
+		})
+	}
+	return relaxations
+}
+
+// solveRelaxed applies the steps to a copy of req and solves it as part of screen,
+// reporting whether the resulting timetable assigns every lesson.
+func solveRelaxed(
+	screen *screening,
+	timetables map[string][]models.ModuleSlot,
+	req models.OptimiserRequest,
+	steps []relaxationStep,
+) (relaxationResult, bool) {
+	relaxed := req
+	relaxed.FreeDays = slices.Clone(req.FreeDays)
+	relaxed.Recordings = slices.Clone(req.Recordings)
+	relaxed.PinnedSlots = slices.Clone(req.PinnedSlots)
+	relaxed.ExcludedSlots = slices.Clone(req.ExcludedSlots)
+	relaxed.Blackouts = slices.Clone(req.Blackouts)
+	cost := 0
+	for _, step := range steps {
+		step.apply(&relaxed)
+		cost += step.cost
+	}
+	if err := relaxed.ParseOptimiserRequestFields(); err != nil {
+		return relaxationResult{}, false
+	}
+
+	space, err := buildSearchSpace(timetables, &relaxed)
+	if err != nil {
+		return relaxationResult{}, false
+	}
+	state, ok := screen.solve(space, relaxed)
+	if !ok || len(state.Assignments) < countLessons(space.defaultSlots) {
+		return relaxationResult{}, false
+	}
+	return relaxationResult{steps: steps, cost: cost, state: state, space: space, req: relaxed}, true
+}
+
+// countLessons returns the number of lessons (module and lesson type pairs) in defaultSlots.
+func countLessons(defaultSlots models.ModuleDefaultSlotsMap) int {
+	count := 0
+	for _, lessonTypeMap := range defaultSlots {
+		count += len(lessonTypeMap)
+	}
+	return count
+}
+
+// relaxationSteps lists every single relaxation of req, in a deterministic order.
+func relaxationSteps(timetables map[string][]models.ModuleSlot, req models.OptimiserRequest) []relaxationStep {
+	var steps []relaxationStep
+
+	for _, freeDay := range req.FreeDays {
+		steps = append(steps, relaxationStep{
+			change: models.RelaxationChange{Kind: models.RelaxationDropFreeDay, Value: freeDay},
+			cost:   1,
+			group:  models.RelaxationDropFreeDay + ":" + freeDay,
+			apply: func(r *models.OptimiserRequest) {
+				r.FreeDays = slices.DeleteFunc(r.FreeDays, func(day string) bool { return day == freeDay })
+			},
+		})
+	}
+
+	for n := 1; n <= constants.RelaxationMaxTimeSteps; n++ {
+		earliestMin := req.EarliestMin - n*constants.RelaxationTimeStep
+		if earliestMin >= 0 {
+			earliestTime := formatMinutes(earliestMin)
+			steps = append(steps, relaxationStep{
+				change: models.RelaxationChange{Kind: models.RelaxationEarlierStart, Value: earliestTime},
+				cost:   n,
+				group:  models.RelaxationEarlierStart,
+				apply: func(r *models.OptimiserRequest) {
+					r.EarliestTime = earliestTime
+					shiftDayTimeWindows(r, -n*constants.RelaxationTimeStep, 0)
+				},
+			})
+		}
+		latestMin := req.LatestMin + n*constants.RelaxationTimeStep
+		if latestMin < 24*60 {
+			latestTime := formatMinutes(latestMin)
+			steps = append(steps, relaxationStep{
+				change: models.RelaxationChange{Kind: models.RelaxationLaterEnd, Value: latestTime},
+				cost:   n,
+				group:  models.RelaxationLaterEnd,
+				apply: func(r *models.OptimiserRequest) {
+					r.LatestTime = latestTime
+					shiftDayTimeWindows(r, 0, n*constants.RelaxationTimeStep)
+				},
+			})
+		}
+	}
+
+	for _, lessonKey := range unrecordedLectures(timetables, req) {
+		steps = append(steps, relaxationStep{
+			change: models.RelaxationChange{Kind: models.RelaxationRecordLesson, Value: lessonKey},
+			cost:   1,
+			group:  models.RelaxationRecordLesson + ":" + lessonKey,
+			apply:  func(r *models.OptimiserRequest) { r.Recordings = append(r.Recordings, lessonKey) },
+		})
+	}
+
+	for _, pinnedSlot := range req.PinnedSlots {
+		steps = append(steps, relaxationStep{
+			change: models.RelaxationChange{Kind: models.RelaxationUnpinSlot, Value: pinnedSlot},
+			cost:   1,
+			group:  models.RelaxationUnpinSlot + ":" + pinnedSlot,
+			apply: func(r *models.OptimiserRequest) {
+				r.PinnedSlots = slices.DeleteFunc(r.PinnedSlots, func(slot string) bool { return slot == pinnedSlot })
+			},
+		})
+	}
+
+	for _, excludedSlot := range req.ExcludedSlots {
+		steps = append(steps, relaxationStep{
+			change: models.RelaxationChange{Kind: models.RelaxationIncludeSlot, Value: excludedSlot},
+			cost:   1,
+			group:  models.RelaxationIncludeSlot + ":" + excludedSlot,
+			apply: func(r *models.OptimiserRequest) {
+				r.ExcludedSlots = slices.DeleteFunc(r.ExcludedSlots, func(slot string) bool { return slot == excludedSlot })
+			},
+		})
+	}
+
+	if req.MaxHoursPerDay > 0 && !req.MaxHoursPerDaySoft {
+		for n := 1; n <= constants.RelaxationMaxHourSteps; n++ {
+			maxHoursPerDay := min(req.MaxHoursPerDay+float64(n), 24)
+			steps = append(steps, relaxationStep{
+				change: models.RelaxationChange{
+					Kind:  models.RelaxationMoreHours,
+					Value: strconv.FormatFloat(maxHoursPerDay, 'g', -1, 64),
+				},
+				cost:  n,
+				group: models.RelaxationMoreHours,
+				apply: func(r *models.OptimiserRequest) { r.MaxHoursPerDay = maxHoursPerDay },
+			})
+		}
+	}
+
+	if req.WalkingSpeed > 0 && !req.TravelTimeSoft {
+		steps = append(steps, relaxationStep{
+			change: models.RelaxationChange{
+				Kind:  models.RelaxationSoftTravel,
+				Value: strconv.FormatFloat(req.WalkingSpeed, 'g', -1, 64),
+			},
+			cost:  1,
+			group: models.RelaxationSoftTravel,
+			apply: func(r *models.OptimiserRequest) { r.TravelTimeSoft = true },
+		})
+	}
+
+	if len(req.AvoidedVenues) > 0 && req.AvoidVenuesHard {
+		steps = append(steps, relaxationStep{
+			change: models.RelaxationChange{
+				Kind:  models.RelaxationSoftVenues,
+				Value: strings.Join(req.AvoidedVenues, ","),
+			},
+			cost:  1,
+			group: models.RelaxationSoftVenues,
+			apply: func(r *models.OptimiserRequest) { r.AvoidVenuesHard = false },
+		})
+	}
+
+	if len(req.ExcludedWeeks) > 0 && !req.ExcludedWeeksSoft {
+		weeks := make([]string, len(req.ExcludedWeeks))
+		for i, week := range req.ExcludedWeeks {
+			weeks[i] = strconv.Itoa(week)
+		}
+		steps = append(steps, relaxationStep{
+			change: models.RelaxationChange{Kind: models.RelaxationSoftWeeks, Value: strings.Join(weeks, ",")},
+			cost:   1,
+			group:  models.RelaxationSoftWeeks,
+			apply:  func(r *models.OptimiserRequest) { r.ExcludedWeeksSoft = true },
+		})
+	}
+
+	for i, blackout := range req.Blackouts {
+		if blackout.Soft {
+			continue
+		}
+		steps = append(steps, relaxationStep{
+			change: models.RelaxationChange{Kind: models.RelaxationSoftBlackout, Value: blackout.String()},
+			cost:   1,
+			group:  fmt.Sprintf("%s:%d", models.RelaxationSoftBlackout, i),
+			apply:  func(r *models.OptimiserRequest) { r.Blackouts[i].Soft = true },
+		})
+	}
+
+	return steps
+}
+
+// shiftDayTimeWindows widens every per-day time window of r like the global one: each
+// earliestTime it sets moves by earliestDelta minutes and each latestTime by latestDelta,
+// within the day. The map is replaced rather than modified, since it is shared with the
+// unrelaxed request.
+func shiftDayTimeWindows(r *models.OptimiserRequest, earliestDelta int, latestDelta int) {
+	shift := func(timeStr string, delta int) string {
+		minutes, err := models.ParseTimeToMinutes(timeStr)
+		if timeStr == "" || err != nil {
+			return timeStr
+		}
+		return formatMinutes(min(max(minutes+delta, 0), 24*60-1))
+	}
+
+	windows := make(map[string]models.TimeWindow, len(r.DayTimeWindows))
+	for day, window := range r.DayTimeWindows {
+		windows[day] = models.TimeWindow{
+			EarliestTime: shift(window.EarliestTime, earliestDelta),
+			LatestTime:   shift(window.LatestTime, latestDelta),
+		}
+	}
+	r.DayTimeWindows = windows
+}
+
+// unrecordedLectures returns the sorted lessonKeys of every lecture-like lesson type (e.g.
+// "Lecture", "Packaged Lecture") of the requested modules that is not already recorded in
+// every class, day and week.
+func unrecordedLectures(timetables map[string][]models.ModuleSlot, req models.OptimiserRequest) []string {
+	recorded := fullyRecordedLessons(req)
+	seen := make(map[string]struct{})
+	var lectures []string
+	for module, timetable := range timetables {
+		for i := range timetable {
+			if !strings.Contains(strings.ToUpper(timetable[i].LessonType), "LECTURE") {
+				continue
+			}
+			lessonKey := strings.ToUpper(module) + "|" + timetable[i].LessonType
+			if _, ok := recorded[lessonKey]; ok {
+				continue
+			}
+			if _, ok := seen[lessonKey]; ok {
+				continue
+			}
+			seen[lessonKey] = struct{}{}
+			lectures = append(lectures, lessonKey)
+		}
+	}
+	sort.Strings(lectures)
+	return lectures
+}
+
+// fullyRecordedLessons returns the lessonKeys that a recording of req records in every
+// class, day and week.
+func fullyRecordedLessons(req models.OptimiserRequest) map[string]struct{} {
+	recorded := make(map[string]struct{}, len(req.RecordingRules))
+	for _, rule := range req.RecordingRules {
+		if rule.ClassNo == "" && rule.Day == "" && rule.WeeksSet == nil {
+			recorded[rule.LessonKey] = struct{}{}
+		}
+	}
+	return recorded
+}
+
+// formatMinutes converts minutes since midnight to "HHMM".
+func formatMinutes(minutes int) string {
+	return fmt.Sprintf("%02d%02d", minutes/60, minutes%60)
+}
@@ -0,0 +1,425 @@
+package solver
+
+import (
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// Names of the score components, as reported in the score breakdown.
+const (
+	ScorerLunch            = "lunch"
+	ScorerGap              = "gap"
+	ScorerConsecutiveHours = "consecutiveHours"
+	ScorerDistance         = "distance"
+	ScorerCampusDays       = "campusDays"
+	ScorerSlotPenalty      = "slotPenalty"
+	ScorerDailyHours       = "dailyHours"
+	ScorerBalance          = "balance"
+	ScorerTravelTime       = "travelTime"
+	ScorerCommute          = "commute"
+)
+
+// ScoreInput is what every Scorer sees of a timetable. The physical slots of each day are
+// computed once and shared, since most components ignore recorded lessons. It is passed by
+// value, since a pointer passed through the interface would move every scored state to the
+// heap.
+type ScoreInput struct {
+	State            models.TimetableState
+	PhysicalSlots    [constants.DaysPerWeek][]models.ModuleSlot // DaySlots without recorded lessons
+	OptimiserRequest models.OptimiserRequest
+	// The timetable of each group of teaching weeks, with weekScoring and a lesson that runs
+	// in some teaching weeks only; nil otherwise (see newWeekViews)
+	Weeks []WeekView
+}
+
+// HasSlots reports whether day d has any slot, recorded or not. Per-day components only
+// score such days, so a day off is neither rewarded nor penalised.
+func (in ScoreInput) HasSlots(d int) bool {
+	return len(in.State.DaySlots[d]) > 0
+}
+
+// ComponentScore is one component's contribution to a timetable's score, split into the
+// part attributable to each day and the part that only makes sense for the whole week.
+type ComponentScore struct {
+	Days [constants.DaysPerWeek]float64
+	Week float64
+}
+
+// Total returns the component's contribution to the score.
+func (c ComponentScore) Total() float64 {
+	total := c.Week
+	for _, day := range c.Days {
+		total += day
+	}
+	return total
+}
+
+// Scorer is a single component of the scoring function. Lower scores are better.
+type Scorer interface {
+	// Name identifies the component in the score breakdown
+	Name() string
+	// Score returns the component's score for a (possibly partial) timetable
+	Score(in ScoreInput) ComponentScore
+	// LowerBound returns a lower bound on the component's score for every complete
+	// timetable extending in.State, where openDays marks the days the remaining lessons can
+	// still add slots to. It must never exceed the true score, or the exact search could
+	// prune the optimum; 0 is a valid bound for any component that is never negative.
+	LowerBound(in ScoreInput, openDays [constants.DaysPerWeek]bool) float64
+}
+
+// scorers are the registered score components, in the order they appear in the breakdown.
+// Components must be stateless, reading their weights from the request.
+//
+//nolint:gochecknoglobals // the registry of score components, shared by every solve
+var scorers = []Scorer{
+	lunchScorer{},
+	gapScorer{},
+	consecutiveHoursScorer{},
+	distanceScorer{},
+	campusDaysScorer{},
+	slotPenaltyScorer{},
+	dailyHoursScorer{},
+	balanceScorer{},
+	travelTimeScorer{},
+	commuteScorer{},
+}
+
+// newScoreInput prepares state for scoring.
+func newScoreInput(
+	state models.TimetableState,
+	optimiserRequest models.OptimiserRequest,
+) ScoreInput {
+	in := ScoreInput{State: state, OptimiserRequest: optimiserRequest}
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		in.PhysicalSlots[d] = getPhysicalSlots(state.DaySlots[d])
+	}
+	in.Weeks = newWeekViews(in)
+	return in
+}
+
+// scoreBreakdown returns the score of state split by day and component. The components'
+// totals add up to state's score.
+func scoreBreakdown(
+	state models.TimetableState,
+	optimiserRequest models.OptimiserRequest,
+) models.ScoreBreakdown {
+	in := newScoreInput(state, optimiserRequest)
+	breakdown := models.ScoreBreakdown{
+		Week:  make(map[string]float64, len(scorers)),
+		Total: make(map[string]float64, len(scorers)),
+	}
+	for d := range breakdown.Days {
+		breakdown.Days[d] = make(map[string]float64, len(scorers))
+	}
+	for _, scorer := range scorers {
+		score := scorer.Score(in)
+		for d, day := range score.Days {
+			breakdown.Days[d][scorer.Name()] = day
+		}
+		breakdown.Week[scorer.Name()] = score.Week
+		breakdown.Total[scorer.Name()] = score.Total()
+	}
+	return breakdown
+}
+
+// lunchScorer rewards each day with a lunch break of at least LunchRequiredTime within the
+// lunch window, and penalises each day without one.
+type lunchScorer struct{}
+
+func (lunchScorer) Name() string { return ScorerLunch }
+
+func (lunchScorer) Score(in ScoreInput) ComponentScore {
+	var score ComponentScore
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		if in.HasSlots(d) {
+			score.Days[d] = in.weekly(d, func(_, physicalSlots []models.ModuleSlot) float64 {
+				return lunchScore(physicalSlots, &in.OptimiserRequest)
+			})
+		}
+	}
+	return score
+}
+
+// LowerBound is bounded from the lunch score's current value: adding slots can only shrink
+// the gaps in the lunch window, so a day that has lost its lunch break cannot regain it. An
+// empty day that can still receive slots may yet earn LunchBonus. The same holds in every
+// teaching week, but with WeekScoringWorst the worst week can change, so the best week's
+// current score is the bound.
+func (lunchScorer) LowerBound(in ScoreInput, openDays [constants.DaysPerWeek]bool) float64 {
+	lunch := func(_, physicalSlots []models.ModuleSlot) float64 {
+		return lunchScore(physicalSlots, &in.OptimiserRequest)
+	}
+	var bound float64
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		if in.HasSlots(d) && in.OptimiserRequest.WeekScoring == models.WeekScoringWorst {
+			bound += in.bestWeek(d, lunch)
+		} else if in.HasSlots(d) {
+			bound += in.weekly(d, lunch)
+		} else if openDays[d] {
+			bound += in.OptimiserRequest.ScoringWeights.LunchBonus
+		}
+	}
+	return bound
+}
+
+// lunchScore returns LunchBonus if the day has a lunch break, NoLunchPenalty otherwise.
+func lunchScore(physicalSlots []models.ModuleSlot, optimiserRequest *models.OptimiserRequest) float64 {
+	if calculateLunchGap(physicalSlots, *optimiserRequest) >= constants.LunchRequiredTime {
+		return optimiserRequest.ScoringWeights.LunchBonus
+	}
+	return optimiserRequest.ScoringWeights.NoLunchPenalty
+}
+
+// gapScorer penalises each day's largest gap between classes beyond GapPenaltyThreshold.
+// A new slot can fill a large gap, so its lower bound is 0.
+type gapScorer struct{}
+
+func (gapScorer) Name() string { return ScorerGap }
+
+func (gapScorer) Score(in ScoreInput) ComponentScore {
+	weights := in.OptimiserRequest.ScoringWeights
+	var score ComponentScore
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		score.Days[d] = in.weekly(d, func(_, physicalSlots []models.ModuleSlot) float64 {
+			largestGap := calculateLargestGap(physicalSlots)
+			if largestGap <= weights.GapPenaltyThreshold {
+				return 0
+			}
+			return weights.GapPenaltyRate * float64(largestGap-weights.GapPenaltyThreshold) / 60
+		})
+	}
+	return score
+}
+
+func (gapScorer) LowerBound(ScoreInput, [constants.DaysPerWeek]bool) float64 { return 0 }
+
+// consecutiveHoursScorer penalises blocks of back-to-back classes over maxConsecutiveHours.
+// Slots in disjoint weeks may overlap in time and break up a block, so its lower bound is 0.
+type consecutiveHoursScorer struct{}
+
+func (consecutiveHoursScorer) Name() string { return ScorerConsecutiveHours }
+
+func (consecutiveHoursScorer) Score(in ScoreInput) ComponentScore {
+	var score ComponentScore
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		score.Days[d] = in.weekly(d, func(_, physicalSlots []models.ModuleSlot) float64 {
+			return scoreConsecutiveHoursOfStudy(
+				physicalSlots,
+				in.OptimiserRequest.MaxConsecutiveHours,
+				in.OptimiserRequest.ScoringWeights.ConsecutiveHoursPenaltyRate,
+			)
+		})
+	}
+	return score
+}
+
+func (consecutiveHoursScorer) LowerBound(ScoreInput, [constants.DaysPerWeek]bool) float64 { return 0 }
+
+// distanceScorer penalises walking between consecutive physical lessons. The per-day
+// distances are maintained incrementally in the state as slots are assigned (see
+// calculateDayDistanceScore), and only recomputed per week view. A recorded slot can split
+// a walk, so its lower bound is 0.
+type distanceScorer struct{}
+
+func (distanceScorer) Name() string { return ScorerDistance }
+
+func (distanceScorer) Score(in ScoreInput) ComponentScore {
+	if in.Weeks == nil {
+		return ComponentScore{Days: in.State.DayDistance}
+	}
+	var score ComponentScore
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		score.Days[d] = in.weekly(d, func(daySlots, _ []models.ModuleSlot) float64 {
+			return calculateDayDistanceScore(daySlots, in.OptimiserRequest.ScoringWeights)
+		})
+	}
+	return score
+}
+
+func (distanceScorer) LowerBound(ScoreInput, [constants.DaysPerWeek]bool) float64 { return 0 }
+
+// campusDaysScorer penalises every day with at least one physical lesson when the request
+// sets minimiseCampusDays, so the solver prefers timetables with more days off campus
+// without the user having to name them as free days. Adding a lesson never takes a day off
+// campus, so the days already on campus are a lower bound.
+type campusDaysScorer struct{}
+
+func (campusDaysScorer) Name() string { return ScorerCampusDays }
+
+func (campusDaysScorer) Score(in ScoreInput) ComponentScore {
+	var score ComponentScore
+	if !in.OptimiserRequest.MinimiseCampusDays {
+		return score
+	}
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		if len(in.PhysicalSlots[d]) > 0 {
+			score.Days[d] = in.OptimiserRequest.ScoringWeights.CampusDayPenalty
+		}
+	}
+	return score
+}
+
+func (s campusDaysScorer) LowerBound(in ScoreInput, _ [constants.DaysPerWeek]bool) float64 {
+	return s.Score(in).Total()
+}
+
+// slotPenaltyScorer adds the soft penalties attached to each slot when the search space was
+// built, e.g. for overlapping a soft blackout. Recorded slots are scored too, since the first
+// slot of a ranked class carries its rank even when recorded; the other penalties are only
+// attached to physical slots. Penalties are never negative and never removed, so the current
+// total is a lower bound.
+type slotPenaltyScorer struct{}
+
+func (slotPenaltyScorer) Name() string { return ScorerSlotPenalty }
+
+func (slotPenaltyScorer) Score(in ScoreInput) ComponentScore {
+	var score ComponentScore
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		for i := range in.State.DaySlots[d] {
+			score.Days[d] += in.State.DaySlots[d][i].Penalty
+		}
+	}
+	return score
+}
+
+func (s slotPenaltyScorer) LowerBound(in ScoreInput, _ [constants.DaysPerWeek]bool) float64 {
+	return s.Score(in).Total()
+}
+
+// dailyHoursScorer penalises each hour of physical lessons over a soft maxHoursPerDay. A
+// hard cap is enforced by canAssign instead. Adding a lesson never shortens a day, so the
+// current penalty is a lower bound.
+type dailyHoursScorer struct{}
+
+func (dailyHoursScorer) Name() string { return ScorerDailyHours }
+
+func (dailyHoursScorer) Score(in ScoreInput) ComponentScore {
+	var score ComponentScore
+	if in.OptimiserRequest.MaxHoursPerDay <= 0 || !in.OptimiserRequest.MaxHoursPerDaySoft {
+		return score
+	}
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		overMinutes := physicalMinutes(in.PhysicalSlots[d]) - maxMinutesPerDay(in.OptimiserRequest)
+		if overMinutes > 0 {
+			score.Days[d] = in.OptimiserRequest.ScoringWeights.DailyHoursPenaltyRate * float64(overMinutes) / 60
+		}
+	}
+	return score
+}
+
+func (s dailyHoursScorer) LowerBound(in ScoreInput, _ [constants.DaysPerWeek]bool) float64 {
+	return s.Score(in).Total()
+}
+
+// balanceScorer penalises an uneven spread of physical hours across the week when the
+// request sets balanceDailyHours: BalancePenaltyRate per hour between the busiest and the
+// lightest day. The days compared are Monday to Friday, except free days, plus Saturday if
+// it has physical lessons; a day without lessons counts as 0 hours, so balancing works
+// against minimiseCampusDays. The spread is a property of the whole week, so it is scored
+// as a week-level component. A new lesson can raise the lightest day, so its lower bound is 0.
+type balanceScorer struct{}
+
+func (balanceScorer) Name() string { return ScorerBalance }
+
+func (balanceScorer) Score(in ScoreInput) ComponentScore {
+	var score ComponentScore
+	if !in.OptimiserRequest.BalanceDailyHours {
+		return score
+	}
+
+	minMinutes, maxMinutes := -1, 0
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		minutes := physicalMinutes(in.PhysicalSlots[d])
+		if in.OptimiserRequest.IsFreeDay[d] || (d == constants.DaysPerWeek-1 && minutes == 0) {
+			continue
+		}
+		if minMinutes < 0 || minutes < minMinutes {
+			minMinutes = minutes
+		}
+		maxMinutes = max(maxMinutes, minutes)
+	}
+	if minMinutes >= 0 {
+		score.Week = in.OptimiserRequest.ScoringWeights.BalancePenaltyRate * float64(maxMinutes-minMinutes) / 60
+	}
+	return score
+}
+
+func (balanceScorer) LowerBound(ScoreInput, [constants.DaysPerWeek]bool) float64 { return 0 }
+
+// travelTimeScorer penalises, with a soft travel time, each pair of physical slots of a day
+// whose break is too short to walk between them at walkingSpeed: TravelTimePenaltyRate per
+// minute short (see travelShortfall). Every pair is checked rather than only neighbouring
+// ones, so adding a lesson never lowers the penalty and the current penalty is a lower bound.
+// A hard travel time is enforced by canAssign instead.
+type travelTimeScorer struct{}
+
+func (travelTimeScorer) Name() string { return ScorerTravelTime }
+
+func (travelTimeScorer) Score(in ScoreInput) ComponentScore {
+	var score ComponentScore
+	if in.OptimiserRequest.WalkingSpeed <= 0 || !in.OptimiserRequest.TravelTimeSoft {
+		return score
+	}
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		slots := in.PhysicalSlots[d]
+		var shortfall float64
+		for i := range slots {
+			for j := i + 1; j < len(slots); j++ {
+				shortfall += travelShortfall(slots[i], slots[j], in.OptimiserRequest.WalkingSpeed)
+			}
+		}
+		score.Days[d] = in.OptimiserRequest.ScoringWeights.TravelTimePenaltyRate * shortfall
+	}
+	return score
+}
+
+func (s travelTimeScorer) LowerBound(in ScoreInput, _ [constants.DaysPerWeek]bool) float64 {
+	return s.Score(in).Total()
+}
+
+// commuteScorer penalises, with a homeLocation, the distance from home to each day's first
+// physical lesson and back from the one that ends last: CommutePenaltyRate per km. Lessons at
+// venues without coordinates are skipped. With weekScoring it is scored per week view, so a
+// lesson held in some weeks only does not add to the commute of the others. A new lesson can
+// replace the first or last lesson with one nearer home, so its lower bound is 0.
+type commuteScorer struct{}
+
+func (commuteScorer) Name() string { return ScorerCommute }
+
+func (commuteScorer) Score(in ScoreInput) ComponentScore {
+	var score ComponentScore
+	if in.OptimiserRequest.HomeLocation == nil {
+		return score
+	}
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		score.Days[d] = in.weekly(d, func(_, physicalSlots []models.ModuleSlot) float64 {
+			km := commuteKm(physicalSlots, in.OptimiserRequest.HomeCoordinates)
+			return in.OptimiserRequest.ScoringWeights.CommutePenaltyRate * km
+		})
+	}
+	return score
+}
+
+// commuteKm returns the distance from home to the first of the day's physical slots that
+// starts and back from the one that ends last, skipping slots without coordinates. Slots are
+// in start order, but one nested in a longer slot does not end last.
+func commuteKm(physicalSlots []models.ModuleSlot, home models.Coordinates) float64 {
+	first, last := -1, -1
+	for i := range physicalSlots {
+		if isInvalidCoordinates(physicalSlots[i].Coordinates) {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if last < 0 || physicalSlots[i].EndMin >= physicalSlots[last].EndMin {
+			last = i
+		}
+	}
+	if first < 0 {
+		return 0
+	}
+	return distanceKm(home, physicalSlots[first].Coordinates) + distanceKm(physicalSlots[last].Coordinates, home)
+}
+
+func (commuteScorer) LowerBound(ScoreInput, [constants.DaysPerWeek]bool) float64 { return 0 }
@@ -0,0 +1,55 @@
+package solver
+
+import (
+	"context"
+	"time"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// screening bounds a series of narrow beam searches that compare variants of a request,
+// such as relaxed requests, combinations of optional modules or requests with recording
+// candidates. It allows at most maxEvaluations solves at beamWidth within its own wall time
+// budget, so the series cannot hold up the response even when the request sets no timeLimitMs.
+type screening struct {
+	ctx            context.Context
+	beamWidth      int
+	maxEvaluations int
+	evaluations    int
+}
+
+// newScreening returns a screening that stops after maxEvaluations solves, once timeLimit
+// has elapsed or when ctx is done. The returned cancel func releases its timer.
+func newScreening(
+	ctx context.Context,
+	maxEvaluations int,
+	beamWidth int,
+	timeLimit time.Duration,
+) (*screening, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeLimit)
+	return &screening{ctx: ctx, beamWidth: beamWidth, maxEvaluations: maxEvaluations}, cancel
+}
+
+// exhausted reports whether the evaluation or time budget has run out.
+func (s *screening) exhausted() bool {
+	return s.evaluations >= s.maxEvaluations || s.ctx.Err() != nil
+}
+
+// solve counts one evaluation and beam searches space at the screening's beam width,
+// returning the best timetable and whether the search ran to completion.
+func (s *screening) solve(space searchSpace, req models.OptimiserRequest) (models.TimetableState, bool) {
+	s.evaluations++
+	beam, truncated := beamSearch(
+		s.ctx,
+		space.lessons,
+		space.lessonToSlots,
+		s.beamWidth,
+		constants.BranchingFactor,
+		req,
+	)
+	if truncated {
+		return models.TimetableState{}, false
+	}
+	return beam[0], true
+}
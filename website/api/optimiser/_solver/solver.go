@@ -1,10 +1,13 @@
 package solver
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/umahmood/haversine"
 
@@ -19,27 +22,166 @@ import (
 //
 //   - validates and normalizes the optimiser request
 //   - fetches all candidate module slots and default timetable data
+//   - with optionalModules, screens every combination of them and keeps the best
+//   - with recommendRecordings, greedily marks the lessons as recorded whose
+//     recordings improve the timetable most
 //   - transforms module lessons into a search space representation
 //   - applies the Minimum Remaining Values (MRV) heuristic by sorting
 //     lessons with fewer class-group options first
-//   - runs beam search to find an optimized timetable assignment
+//   - runs an exact branch-and-bound search for small requests, or beam search
+//     followed by a local search refinement otherwise, to find an optimized
+//     timetable assignment
 //   - generates shareable NUSMods links for both the optimized and
 //     default timetables
 //
+// The search stops early when ctx is cancelled or the request's timeLimitMs
+// elapses, in which case the best timetable found so far is returned and the
+// response is marked as truncated.
+//
 // It returns a SolveResponse containing the optimized timetable state
 // and generated shareable links.
-func Solve(req models.OptimiserRequest) (models.SolveResponse, error) {
+func Solve(ctx context.Context, req models.OptimiserRequest) (models.SolveResponse, error) {
 	if err := req.ParseOptimiserRequestFields(); err != nil {
 		return models.SolveResponse{}, &models.SolveError{Code: http.StatusBadRequest, Message: err.Error()}
 	}
+	scoringWeights, err := resolveScoringWeights(req.Weights)
+	if err != nil {
+		return models.SolveResponse{}, &models.SolveError{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	req.ScoringWeights = scoringWeights
 
-	slots, defaultSlots, recordings, err := modules.GetAllModuleSlots(&req)
+	if req.TimeLimitMs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeLimitMs)*time.Millisecond)
+		defer cancel()
+	}
+
+	timetables, err := modules.FetchModuleTimetables(ctx, &req)
 	if err != nil {
-		var solveErr *models.SolveError
-		if errors.As(err, &solveErr) {
-			return models.SolveResponse{}, solveErr
+		return models.SolveResponse{}, asSolveError(err)
+	}
+
+	// With optionalModules, the chosen combination's request and search space replace the
+	// request's own, and the timetables of the modules not taken are dropped
+	var space searchSpace
+	var choice moduleChoice
+	if len(req.OptionalModules) > 0 {
+		choice, err = chooseModules(ctx, timetables, req)
+		if err != nil {
+			return models.SolveResponse{}, asSolveError(err)
+		}
+		req, space, timetables = choice.req, choice.space, choice.timetables
+	} else {
+		space, err = buildSearchSpace(timetables, &req)
+		if err != nil {
+			return models.SolveResponse{}, asSolveError(err)
 		}
-		return models.SolveResponse{}, &models.SolveError{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	// With recommendRecordings, the recommended lessons are recorded in the request, so the
+	// search space is rebuilt with them
+	var recommendations []models.RecordingRecommendation
+	if req.RecommendRecordings > 0 {
+		recommendations, req, err = recommendRecordings(ctx, timetables, req)
+		if err != nil {
+			return models.SolveResponse{}, asSolveError(err)
+		}
+		if len(recommendations) > 0 {
+			space, err = buildSearchSpace(timetables, &req)
+			if err != nil {
+				return models.SolveResponse{}, asSolveError(err)
+			}
+		}
+	}
+	defaultSlots := space.defaultSlots
+
+	result := searchTimetables(ctx, space.lessons, space.lessonToSlots, req)
+	best := result.states[0]
+
+	// A truncated search only holds partial timetables, or the complete ones found before
+	// the deadline, which are not worth offering
+	var alternatives []models.Alternative
+	if !result.truncated {
+		alternatives = generateAlternatives(
+			selectDiverseStates(result.states, min(req.Alternatives, constants.MaxAlternatives)),
+			defaultSlots,
+			req,
+		)
+	}
+
+	breakdown := scoreBreakdown(best, req)
+	dayHours := physicalHoursPerDay(best)
+
+	// Must be collected before the shareable links are generated, since that fills
+	// the unassigned lessons into best.Assignments.
+	unassignedLessons := explainUnassignedLessons(
+		best,
+		result.truncated,
+		space.lessonToSlots,
+		space.filteredReasons,
+		defaultSlots,
+		req,
+	)
+
+	// Relaxations are only worth searching for when preferences, not the deadline, left
+	// lessons unassigned
+	var relaxations []models.Relaxation
+	if !result.truncated && hasUnsatisfiableLessons(unassignedLessons) {
+		relaxations = suggestRelaxations(ctx, timetables, req)
+	}
+
+	shareableLink, defaultShareableLink := FillDefaultsAndGenerateShareableLinks(
+		best.Assignments,
+		defaultSlots,
+		req,
+	)
+	response := models.SolveResponse{
+		TimetableState:           best,
+		ScoreBreakdown:           breakdown,
+		DayHours:                 dayHours,
+		ShareableLink:            shareableLink,
+		DefaultShareableLink:     defaultShareableLink,
+		Truncated:                result.truncated,
+		ProvenOptimal:            result.provenOptimal,
+		LocalSearchImprovement:   result.improvement,
+		LocalSearchLessonsGained: result.lessonsGained,
+		UnassignedLessons:        unassignedLessons,
+		Alternatives:             alternatives,
+		Relaxations:              relaxations,
+		ChosenModules:            choice.chosen,
+		ModuleCombinations:       choice.runnersUp,
+		RecommendedRecordings:    recommendations,
+	}
+	return response, nil
+}
+
+// asSolveError returns err as a SolveError, keeping the status code of a SolveError
+// and treating anything else as an internal server error.
+func asSolveError(err error) *models.SolveError {
+	var solveErr *models.SolveError
+	if errors.As(err, &solveErr) {
+		return solveErr
+	}
+	return &models.SolveError{Code: http.StatusInternalServerError, Message: err.Error()}
+}
+
+// searchSpace holds the lessons of a request and the class options to search over.
+type searchSpace struct {
+	lessons         []string // Pinned lessons first, then fewest options first
+	lessonToSlots   map[string][][]models.ModuleSlot
+	defaultSlots    models.ModuleDefaultSlotsMap
+	filteredReasons map[string][]string // lessonKey -> constraints that removed all its classes
+}
+
+// buildSearchSpace filters and merges the fetched module timetables for req, and orders
+// the resulting lessons for the search.
+func buildSearchSpace(
+	timetables map[string][]models.ModuleSlot,
+	req *models.OptimiserRequest,
+) (searchSpace, error) {
+	slots, defaultSlots, filteredReasons, err := modules.BuildModuleSlots(timetables, req)
+	if err != nil {
+		return searchSpace{}, err
 	}
 
 	var lessons []string
@@ -54,6 +196,10 @@ func Solve(req models.OptimiserRequest) (models.SolveResponse, error) {
 		}
 	}
 
+	if err := validatePinnedDailyHours(lessonToSlots, *req); err != nil {
+		return searchSpace{}, err
+	}
+
 	// Pinned lessons are always ordered before non-pinned ones, so a pin always claims its
 	// slot in the beam before an unrelated single-option lesson can occupy it and force the
 	// pin to be dropped by hasConflict. Ties within each group fall back to the Minimum
@@ -67,18 +213,127 @@ func Solve(req models.OptimiserRequest) (models.SolveResponse, error) {
 		return len(lessonToSlots[lessons[i]]) < len(lessonToSlots[lessons[j]])
 	})
 
-	best := beamSearch(lessons, lessonToSlots, constants.BeamWidth, constants.BranchingFactor, recordings, req)
-	shareableLink, defaultShareableLink := FillDefaultsAndGenerateShareableLinks(
-		best.Assignments,
-		defaultSlots,
+	return searchSpace{
+		lessons:         lessons,
+		lessonToSlots:   lessonToSlots,
+		defaultSlots:    defaultSlots,
+		filteredReasons: filteredReasons,
+	}, nil
+}
+
+// selectDiverseStates greedily picks up to k states from the score-sorted beam, skipping
+// beam[0] (the best timetable) and any state within AlternativeMinHammingDistance of the
+// best or of an already picked state. This keeps the alternatives from being near-copies
+// of the winner that only swap a single tutorial.
+func selectDiverseStates(beam []models.TimetableState, k int) []models.TimetableState {
+	if k <= 0 || len(beam) <= 1 {
+		return nil
+	}
+
+	picked := []models.TimetableState{beam[0]}
+	for i := 1; i < len(beam) && len(picked) <= k; i++ {
+		isDiverse := true
+		for j := range picked {
+			if hammingDistance(beam[i].Assignments, picked[j].Assignments) < constants.AlternativeMinHammingDistance {
+				isDiverse = false
+				break
+			}
+		}
+		if isDiverse {
+			picked = append(picked, beam[i])
+		}
+	}
+	return picked[1:]
+}
+
+// hammingDistance counts the lessonKeys assigned to a different class (or assigned in only
+// one of the two assignment maps).
+func hammingDistance(a, b map[string]string) int {
+	distance := 0
+	for lessonKey, classNo := range a {
+		if other, ok := b[lessonKey]; !ok || other != classNo {
+			distance++
+		}
+	}
+	for lessonKey := range b {
+		if _, ok := a[lessonKey]; !ok {
+			distance++
+		}
+	}
+	return distance
+}
+
+// generateAlternatives builds the response entries, including a shareable link, for each
+// alternative state.
+func generateAlternatives(
+	states []models.TimetableState,
+	defaultSlots models.ModuleDefaultSlotsMap,
+	req models.OptimiserRequest,
+) []models.Alternative {
+	alternatives := make([]models.Alternative, 0, len(states))
+	for _, state := range states {
+		shareableLink, _ := FillDefaultsAndGenerateShareableLinks(state.Assignments, defaultSlots, req)
+		alternatives = append(alternatives, models.Alternative{
+			Assignments:   state.Assignments,
+			Score:         state.Score,
+			ShareableLink: shareableLink,
+		})
+	}
+	return alternatives
+}
+
+// searchResult is the outcome of searching the timetable space with either solver mode.
+type searchResult struct {
+	states        []models.TimetableState // Candidate timetables sorted by score, best first
+	truncated     bool                    // The search was stopped by ctx before it finished
+	provenOptimal bool                    // states[0] is proven to be the best complete timetable
+	improvement   float64                 // Score decrease of states[0] from local search refinement
+	lessonsGained int                     // Skipped lessons local search refinement assigned
+}
+
+// searchTimetables picks the solver mode for the request. When every combination of
+// classes fits within ExactSearchMaxSpace, the exact branch-and-bound search runs first,
+// since it is fast at that size and can prove its answer optimal. Beam search is used for
+// larger requests, and as the fallback when the exact search finds no timetable that
+// assigns every lesson (beam search skips unassignable lessons instead of failing). The
+// beam search result is then refined by local search, which the exact result cannot need.
+func searchTimetables(
+	ctx context.Context,
+	lessons []string,
+	lessonToSlots map[string][][]models.ModuleSlot,
+	req models.OptimiserRequest,
+) searchResult {
+	if estimateSearchSpace(lessons, lessonToSlots, constants.ExactSearchMaxSpace) <= constants.ExactSearchMaxSpace {
+		poolSize := 1
+		if req.Alternatives > 0 {
+			poolSize = constants.ExactSearchPoolSize
+		}
+		pool, complete := exactSearch(ctx, lessons, lessonToSlots, poolSize, req)
+		if len(pool) > 0 {
+			// A pool cut short by ctx only holds the timetables found so far
+			return searchResult{states: pool, truncated: !complete, provenOptimal: complete}
+		}
+	}
+
+	beam, truncated := beamSearch(
+		ctx,
+		lessons,
+		lessonToSlots,
+		constants.BeamWidth,
+		constants.BranchingFactor,
 		req,
 	)
-	response := models.SolveResponse{
-		TimetableState:       best,
-		ShareableLink:        shareableLink,
-		DefaultShareableLink: defaultShareableLink,
+	result := searchResult{states: beam, truncated: truncated}
+	if !truncated {
+		result.states[0], result.improvement, result.lessonsGained = refineTimetable(
+			ctx,
+			beam[0],
+			lessons,
+			lessonToSlots,
+			req,
+		)
 	}
-	return response, nil
+	return result
 }
 
 // BeamSearch explores the space of possible timetables to find the optimal assignment.
@@ -96,73 +351,34 @@ func Solve(req models.OptimiserRequest) (models.SolveResponse, error) {
 //   - lessonToSlots: Maps each lesson key to its available class options
 //   - beamWidth: Maximum number of partial timetables to keep at each step (trades quality for speed)
 //   - branchingFactor: Maximum number of class options to try per lesson (limits exploration)
-//   - recordings: Set of recorded/online lessons that don't count for physical constraints
 //   - optimiserRequest: User preferences (free days, time ranges, etc.)
 //
-// Returns the best complete timetable found.
+// Returns the final beam sorted by score, so beam[0] is the best complete timetable found.
+// If ctx is done before every lesson has been expanded, the search stops and returns the
+// beam of the last fully expanded step, with truncated set to true.
 // Reference: https://www.geeksforgeeks.org/introduction-to-beam-search-algorithm/
 func beamSearch(
+	ctx context.Context,
 	lessons []string,
 	lessonToSlots map[string][][]models.ModuleSlot,
 	beamWidth int,
 	branchingFactor int,
-	recordings map[string]struct{},
-	optimiserRequest models.OptimiserRequest) models.TimetableState {
+	optimiserRequest models.OptimiserRequest) ([]models.TimetableState, bool) {
 
-	initial := models.TimetableState{
-		Assignments: make(map[string]string),
-	}
-	for d := 0; d < constants.DaysPerWeek; d++ {
-		initial.DaySlots[d] = make([]models.ModuleSlot, 0)
-	}
-	beam := []models.TimetableState{initial}
+	beam := []models.TimetableState{newEmptyState(optimiserRequest)}
 
 	for _, lessonKey := range lessons {
-		slotGroups := lessonToSlots[lessonKey]
-		limit := min(len(slotGroups), branchingFactor)
-		nextBeam := make([]models.TimetableState, 0, len(beam)*limit)
-
-		// Filter valid slot groups
-		validGroups := make([][]models.ModuleSlot, 0, limit)
-		for i := 0; i < limit; i++ {
-			group := slotGroups[i]
-			validGroup := make([]models.ModuleSlot, 0, len(group))
-			for slotIdx := range group {
-				slot := &group[slotIdx]
-				if slot.DayIndex >= 0 && slot.DayIndex < constants.DaysPerWeek {
-					validGroup = append(validGroup, *slot)
-				}
-			}
-			if len(validGroup) > 0 {
-				validGroups = append(validGroups, validGroup)
-			}
+		if ctx.Err() != nil {
+			return beam, true
 		}
 
-		// iterate over all partial timetables in the beam
-		for _, state := range beam {
-
-			// iterate over all pre-filtered slot groups for the current lesson
-			for _, validGroup := range validGroups {
-				if hasConflict(state, validGroup) {
-					continue
-				}
-
-				newState := copyState(state)
-				newState.Assignments[lessonKey] = validGroup[0].ClassNo
-
-				// Track days that change, so we only recalc distance on those days
-				for _, slot := range validGroup {
-					d := slot.DayIndex
+		validGroups := filterValidGroups(lessonToSlots[lessonKey], branchingFactor)
 
-					newState.TotalDistance -= newState.DayDistance[d]
-
-					newState.DaySlots[d] = insertSlotSorted(newState.DaySlots[d], slot)
-					newState.DayDistance[d] = calculateDayDistanceScore(newState.DaySlots[d], recordings)
-					newState.TotalDistance += newState.DayDistance[d]
-				}
-
-				nextBeam = append(nextBeam, newState)
-			}
+		// A half-expanded nextBeam is not comparable across states, so on cancellation
+		// fall back to the previous step's beam, which is fully scored and sorted.
+		nextBeam, cancelled := expandBeam(ctx, beam, lessonKey, validGroups, optimiserRequest)
+		if cancelled {
+			return beam, true
 		}
 
 		// if no valid partial timetables found then skip to next lesson
@@ -171,9 +387,6 @@ func beamSearch(
 			continue
 		}
 
-		for i := range nextBeam {
-			nextBeam[i].Score = scoreTimetableState(nextBeam[i], recordings, optimiserRequest)
-		}
 		sort.Slice(nextBeam, func(i, j int) bool {
 			return nextBeam[i].Score < nextBeam[j].Score
 		})
@@ -186,7 +399,71 @@ func beamSearch(
 		beam = nextBeam
 	}
 
-	return beam[0]
+	return beam, false
+}
+
+// newEmptyState returns a timetable state with no lessons assigned. With weekScoring, its
+// WeekGroups start as one group of every teaching week.
+func newEmptyState(optimiserRequest models.OptimiserRequest) models.TimetableState {
+	state := models.TimetableState{
+		Assignments: make(map[string]string),
+	}
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		state.DaySlots[d] = make([]models.ModuleSlot, 0)
+	}
+	if weeks := optimiserRequest.TeachingWeeks; optimiserRequest.WeekScoring != "" && len(weeks) > 0 {
+		state.WeekGroups = newWeekGroups(uint64(1)<<len(weeks)-1, state.DaySlots)
+	}
+	return state
+}
+
+// filterValidGroups returns up to the first limit slot groups of a lesson, with slots on an
+// unparseable day dropped and groups left empty by that removed.
+func filterValidGroups(slotGroups [][]models.ModuleSlot, limit int) [][]models.ModuleSlot {
+	limit = min(len(slotGroups), limit)
+	validGroups := make([][]models.ModuleSlot, 0, limit)
+	for i := 0; i < limit; i++ {
+		group := slotGroups[i]
+		validGroup := make([]models.ModuleSlot, 0, len(group))
+		for slotIdx := range group {
+			slot := &group[slotIdx]
+			if slot.DayIndex >= 0 && slot.DayIndex < constants.DaysPerWeek {
+				validGroup = append(validGroup, *slot)
+			}
+		}
+		if len(validGroup) > 0 {
+			validGroups = append(validGroups, validGroup)
+		}
+	}
+	return validGroups
+}
+
+// assignGroup returns a copy of state with lessonKey assigned to the class in group. The
+// caller must have checked the group against canAssign. Only the days the group touches
+// have their walking distance recalculated. The returned state is not scored.
+func assignGroup(
+	state models.TimetableState,
+	lessonKey string,
+	group []models.ModuleSlot,
+	weights models.ScoringWeights,
+) models.TimetableState {
+	newState := copyState(state)
+	newState.Assignments[lessonKey] = group[0].ClassNo
+
+	for _, slot := range group {
+		d := slot.DayIndex
+
+		newState.TotalDistance -= newState.DayDistance[d]
+
+		newState.DaySlots[d] = insertSlotSorted(newState.DaySlots[d], slot)
+		newState.DayDistance[d] = calculateDayDistanceScore(newState.DaySlots[d], weights)
+		newState.TotalDistance += newState.DayDistance[d]
+
+		if newState.WeekGroups != nil {
+			newState.WeekGroups = addToWeekGroups(newState.WeekGroups, slot)
+		}
+	}
+	return newState
 }
 
 // hasConflict checks if adding newSlots would create a scheduling conflict with existing
@@ -195,30 +472,45 @@ func beamSearch(
 //  2. Slots occur in the same week (week numbers overlap)
 //
 // This prevents double-booking where a student would need to attend two classes simultaneously.
-// For slots without week information (non-array weeks), assumes conflict if times overlap.
+// For slots without parsed weeks, or with teaching weeks against the dates of a WeekRange,
+// assumes conflict if times overlap.
 func hasConflict(state models.TimetableState, newSlots []models.ModuleSlot) bool {
 	for _, newSlot := range newSlots {
 		for _, oldSlot := range state.DaySlots[newSlot.DayIndex] {
-			// Check if slots overlap in time
-			if newSlot.StartMin < oldSlot.EndMin && oldSlot.StartMin < newSlot.EndMin {
-
-				// if weeks is not a []int, then skip checking for week conflict
-				if newSlot.WeeksSet == nil || oldSlot.WeeksSet == nil {
-					return true
-				}
-
-				// check if the weeks overlap
-				for week := range newSlot.WeeksSet {
-					if _, exists := oldSlot.WeeksSet[week]; exists {
-						return true
-					}
-				}
+			if slotsClash(newSlot, oldSlot) {
+				return true
 			}
 		}
 	}
 	return false
 }
 
+// slotsClash checks if two slots on the same day overlap in time and share a week.
+func slotsClash(newSlot models.ModuleSlot, oldSlot models.ModuleSlot) bool {
+	// Check if slots overlap in time
+	if newSlot.StartMin >= oldSlot.EndMin || oldSlot.StartMin >= newSlot.EndMin {
+		return false
+	}
+
+	return sharesWeek(newSlot, oldSlot)
+}
+
+// sharesWeek checks if two slots occur in a common week.
+func sharesWeek(a models.ModuleSlot, b models.ModuleSlot) bool {
+	// Teaching weeks cannot be compared with calendar weeks, so only like weeks are checked
+	if a.WeeksSet == nil || b.WeeksSet == nil || a.CalendarWeeks != b.CalendarWeeks {
+		return true
+	}
+
+	// check if the weeks overlap
+	for week := range a.WeeksSet {
+		if _, exists := b.WeeksSet[week]; exists {
+			return true
+		}
+	}
+	return false
+}
+
 // copyState creates a deep copy of a timetable state to avoid mutation issues when
 // exploring different branches in the beam search. All maps and slices are copied
 // to ensure changes to the new state don't affect the original.
@@ -227,6 +519,8 @@ func copyState(src models.TimetableState) models.TimetableState {
 		Assignments:   make(map[string]string, len(src.Assignments)),
 		DayDistance:   src.DayDistance,
 		TotalDistance: src.TotalDistance,
+		// Week groups are never changed in place, so they are shared
+		WeekGroups: src.WeekGroups,
 	}
 
 	// Copy assignments
@@ -274,10 +568,13 @@ func insertSlotSorted(daySlots []models.ModuleSlot, newSlot models.ModuleSlot) [
 //
 // The penalty increases linearly with distance using the formula:
 //
-//	penalty = (10.0 / MaxWalkDistance) * distance_in_km
+//	penalty = (10.0 / weights.MaxWalkDistance) * distance_in_km
 //
 // This encourages timetables with classes in nearby venues.
-func calculateDayDistanceScore(daySlots []models.ModuleSlot, recordings map[string]struct{}) float64 {
+func calculateDayDistanceScore(
+	daySlots []models.ModuleSlot,
+	weights models.ScoringWeights,
+) float64 {
 	if len(daySlots) <= 1 {
 		return 0
 	}
@@ -288,37 +585,31 @@ func calculateDayDistanceScore(daySlots []models.ModuleSlot, recordings map[stri
 		prev := daySlots[i-1]
 		curr := daySlots[i]
 
-		// Skip if either lesson is recorded
-		if isLessonRecorded(prev.LessonKey, recordings) ||
-			isLessonRecorded(curr.LessonKey, recordings) {
+		// Skip if either slot is recorded
+		if prev.Recorded || curr.Recorded {
 			continue
 		}
 
 		if isInvalidCoordinates(prev.Coordinates) || isInvalidCoordinates(curr.Coordinates) {
 			// Unknown venue - penalise appropriately
-			totalPenalty += constants.NoVenuePenalty
+			totalPenalty += weights.NoVenuePenalty
 			continue
 		}
 
 		// Both have valid coordinates — calculate actual distance
-		prevCoord := haversine.Coord{Lat: prev.Coordinates.Y, Lon: prev.Coordinates.X}
-		currCoord := haversine.Coord{Lat: curr.Coordinates.Y, Lon: curr.Coordinates.X}
-		_, km := haversine.Distance(prevCoord, currCoord)
+		km := distanceKm(prev.Coordinates, curr.Coordinates)
 
 		// Apply walking penalty formula
 		// A linear penalty applied. Change if a better heuristic is found. Works as of 6/6/2025.
-		totalPenalty += (10.0 / constants.MaxWalkDistance) * km
+		totalPenalty += (10.0 / weights.MaxWalkDistance) * km
 	}
 	return totalPenalty
 }
 
-// isLessonRecorded determines if a lesson is marked as recorded/online by the user.
-// Recorded lessons don't require physical attendance, so they're excluded from distance
-// calculations and free day constraints.
-// lessonKey is in "MODULE|LessonType" format, matching the recordings map keys.
-func isLessonRecorded(lessonKey string, recordings map[string]struct{}) bool {
-	_, ok := recordings[lessonKey]
-	return ok
+// distanceKm returns the haversine distance between two venues in kilometres.
+func distanceKm(a models.Coordinates, b models.Coordinates) float64 {
+	_, km := haversine.Distance(haversine.Coord{Lat: a.Y, Lon: a.X}, haversine.Coord{Lat: b.Y, Lon: b.X})
+	return km
 }
 
 // isInvalidCoordinates checks if coordinates passed are valid
@@ -329,58 +620,37 @@ func isInvalidCoordinates(coord models.Coordinates) bool {
 // scoreTimetableState assigns a heuristic score to a timetable state to determine its quality.
 // Lower scores indicate better (more preferred) timetables.
 //
-// The scoring function combines multiple factors:
+// The score is the sum of the registered scorers (see scorers.go), each weighted by
+// optimiserRequest.ScoringWeights:
 //   - Lunch break availability: Bonus if >= 60min gap in lunch window, penalty otherwise
 //   - Large gaps between classes: Penalizes gaps > 2 hours to avoid excessive downtime
 //   - Consecutive hours: Penalizes too many back-to-back classes without breaks
 //   - Walking distance: Accumulated distance penalties between physical lesson venues from all days
 func scoreTimetableState(
 	state models.TimetableState,
-	recordings map[string]struct{},
 	optimiserRequest models.OptimiserRequest,
 ) float64 {
+	in := newScoreInput(state, optimiserRequest)
 	var totalScore float64
-	for d := 0; d < constants.DaysPerWeek; d++ {
-		if len(state.DaySlots[d]) == 0 {
-			continue
-		}
-
-		physicalSlots := getPhysicalSlots(state.DaySlots[d], recordings)
-
-		// Apply lunch penalty/bonus
-		lunchGap := calculateLunchGap(physicalSlots, optimiserRequest)
-		if lunchGap >= constants.LunchRequiredTime {
-			totalScore += constants.LunchBonus
-		} else {
-			totalScore += constants.NoLunchPenalty
-		}
-
-		// Apply gap penalty for large gaps of > 2 hours between classes
-		largestGap := calculateLargestGap(physicalSlots)
-		if largestGap > constants.GapPenaltyThreshold {
-			totalScore += constants.GapPenaltyRate * float64(largestGap-constants.GapPenaltyThreshold) / 60
-		}
-
-		// Apply penalty for more than max consecutive hours of study
-		totalScore += float64(scoreConsecutiveHoursOfStudy(physicalSlots, optimiserRequest.MaxConsecutiveHours))
+	for _, scorer := range scorers {
+		totalScore += scorer.Score(in).Total()
 	}
-
-	// Add penalty for walking distance
-	return totalScore + state.TotalDistance
+	return totalScore
 }
 
-// getPhysicalSlots filters out recorded lessons from a day's schedule, returning
-// only lessons that require physical attendance. This is used when evaluating constraints
-// that only apply to in-person classes (e.g., lunch breaks, consecutive hours on campus).
-func getPhysicalSlots(daySlots []models.ModuleSlot, recordings map[string]struct{}) []models.ModuleSlot {
-	if len(daySlots) == 0 || len(recordings) == 0 {
+// getPhysicalSlots filters out recorded slots (see models.RecordingRule) from a day's
+// schedule, returning only slots that require physical attendance. This is used when
+// evaluating constraints that only apply to in-person classes (e.g., lunch breaks,
+// consecutive hours on campus).
+func getPhysicalSlots(daySlots []models.ModuleSlot) []models.ModuleSlot {
+	if !slices.ContainsFunc(daySlots, func(slot models.ModuleSlot) bool { return slot.Recorded }) {
 		return daySlots
 	}
 
 	physicalSlots := make([]models.ModuleSlot, 0, len(daySlots))
 	for i := range daySlots {
 		slot := &daySlots[i]
-		if !isLessonRecorded(slot.LessonKey, recordings) {
+		if !slot.Recorded {
 			physicalSlots = append(physicalSlots, *slot)
 		}
 	}
@@ -393,6 +663,12 @@ func getPhysicalSlots(daySlots []models.ModuleSlot, recordings map[string]struct
 // consecutive classes, and after the last class, but only counts time that falls within
 // the specified lunch window (lunchStart to lunchEnd).
 //
+// Time before the first class and after the last class counts even if it lies outside the
+// global earliest/latest time, since the user is free to have lunch off campus then. Only a
+// bound that dayTimeWindows changes for the day clamps the lunch window, so e.g. a Friday
+// overridden to end at 1200 has no lunch gap after its last class, while a request whose
+// global latestTime is 1200 scores lunch as before.
+//
 // Returns the best available gap in minutes. If the gap is >= LunchRequiredTime (60 min),
 // the timetable receives a bonus; otherwise it's penalized.
 func calculateLunchGap(physicalSlots []models.ModuleSlot, optimiserRequest models.OptimiserRequest) int {
@@ -402,7 +678,17 @@ func calculateLunchGap(physicalSlots []models.ModuleSlot, optimiserRequest model
 
 	lunchStart := optimiserRequest.LunchStartMin
 	lunchEnd := optimiserRequest.LunchEndMin
+	d := physicalSlots[0].DayIndex
+	if earliestMin := optimiserRequest.DayEarliestMin[d]; earliestMin != optimiserRequest.EarliestMin {
+		lunchStart = max(lunchStart, earliestMin)
+	}
+	if latestMin := optimiserRequest.DayLatestMin[d]; latestMin != optimiserRequest.LatestMin {
+		lunchEnd = min(lunchEnd, latestMin)
+	}
 	bestGap := 0
+	if lunchStart >= lunchEnd {
+		return bestGap
+	}
 
 	// Gap before first class
 	if physicalSlots[0].StartMin > lunchStart {
@@ -461,12 +747,16 @@ func calculateLargestGap(physicalSlots []models.ModuleSlot) int {
 //  3. In the last iteration of the loop, check the final consecutive block
 //
 // This encourages timetables with breaks between classes to avoid student burnout.
-func scoreConsecutiveHoursOfStudy(physicalSlots []models.ModuleSlot, maxConsecutiveHours int) int {
+func scoreConsecutiveHoursOfStudy(
+	physicalSlots []models.ModuleSlot,
+	maxConsecutiveHours int,
+	penaltyRate float64,
+) float64 {
 	if len(physicalSlots) == 0 {
 		return 0
 	}
 
-	score := 0
+	var score float64
 	consecutiveMinutes := 0
 
 	for i := 0; i < len(physicalSlots); i++ {
@@ -486,13 +776,13 @@ func scoreConsecutiveHoursOfStudy(physicalSlots []models.ModuleSlot, maxConsecut
 			consecutiveMinutes += currentSlotEndMin - currentSlotStartMin
 		} else {
 			// Gap detected, score the consecutive hours so far
-			score += penaliseConsecutiveHoursOfStudy(consecutiveMinutes, maxConsecutiveHours)
+			score += penaliseConsecutiveHoursOfStudy(consecutiveMinutes, maxConsecutiveHours, penaltyRate)
 			consecutiveMinutes = currentSlotEndMin - currentSlotStartMin
 		}
 
 		// If it's the last slot, score the consecutive hours
 		if i == len(physicalSlots)-1 {
-			score += penaliseConsecutiveHoursOfStudy(consecutiveMinutes, maxConsecutiveHours)
+			score += penaliseConsecutiveHoursOfStudy(consecutiveMinutes, maxConsecutiveHours, penaltyRate)
 		}
 	}
 
@@ -501,11 +791,11 @@ func scoreConsecutiveHoursOfStudy(physicalSlots []models.ModuleSlot, maxConsecut
 
 // penaliseConsecutiveHoursOfStudy returns a penalty score for a block of consecutive class time.
 // Returns 0 if within the allowed maximum, otherwise returns a penalty proportional to
-// how many hours over the limit (excess_hours * ConsecutiveHoursPenaltyRate).
-func penaliseConsecutiveHoursOfStudy(consecutiveMinutes int, maxConsecutiveHours int) int {
+// how many hours over the limit (excess_hours * penaltyRate).
+func penaliseConsecutiveHoursOfStudy(consecutiveMinutes int, maxConsecutiveHours int, penaltyRate float64) float64 {
 	consecutiveHours := consecutiveMinutes / 60
 	if consecutiveHours <= maxConsecutiveHours {
 		return 0
 	}
-	return (consecutiveHours - maxConsecutiveHours) * constants.ConsecutiveHoursPenaltyRate
+	return float64(consecutiveHours-maxConsecutiveHours) * penaltyRate
 }
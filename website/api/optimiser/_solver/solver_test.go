@@ -0,0 +1,702 @@
+package solver
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// These tests run the search on synthetic timetables, so they need neither the module API
+// nor the test server.
+
+// TestSearchTimetables_CancelledContextTruncates verifies that a solve whose deadline has
+// elapsed before the search starts is truncated, and that every lesson is then explained
+// as cut off by the time limit.
+func TestSearchTimetables_CancelledContextTruncates(t *testing.T) {
+	lessons, lessonToSlots := testSpace(5, 4)
+	req := testRequest()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result := searchTimetables(ctx, lessons, lessonToSlots, req)
+
+	if !result.truncated || result.provenOptimal {
+		t.Fatalf("Expected a truncated, unproven result, got truncated=%v provenOptimal=%v",
+			result.truncated, result.provenOptimal)
+	}
+	unassigned := explainUnassignedLessons(
+		result.states[0],
+		result.truncated,
+		lessonToSlots,
+		nil,
+		testDefaultSlots(lessons, lessonToSlots),
+		req,
+	)
+	if len(unassigned) != len(lessons) {
+		t.Fatalf("Expected all %d lessons unassigned, got %v", len(lessons), unassigned)
+	}
+	for _, lesson := range unassigned {
+		if lesson.Reason != models.UnassignedReasonTimeLimit {
+			t.Errorf("Expected %s unassigned by %s, got %s", lesson.LessonKey, models.UnassignedReasonTimeLimit, lesson.Reason)
+		}
+	}
+}
+
+// TestSearchTimetables_CancelledExactSearchIsTruncated stops the exact search right after its
+// first complete timetable: the result must be that timetable, marked truncated rather
+// than proven optimal.
+func TestSearchTimetables_CancelledExactSearchIsTruncated(t *testing.T) {
+	lessons, lessonToSlots := testSpace(4, 4)
+	req := testRequest()
+	// One check per depth reaches the first leaf, the next one cancels
+	ctx := &countdownContext{Context: context.Background(), remaining: len(lessons) + 1}
+
+	result := searchTimetables(ctx, lessons, lessonToSlots, req)
+
+	if !result.truncated || result.provenOptimal {
+		t.Fatalf("Expected a truncated, unproven result, got truncated=%v provenOptimal=%v",
+			result.truncated, result.provenOptimal)
+	}
+	if len(result.states) == 0 || len(result.states[0].Assignments) != len(lessons) {
+		t.Fatalf("Expected the complete timetable found before the deadline, got %v", result.states)
+	}
+}
+
+// TestExplainUnassignedLessons_ClashOnlyWhenEveryClassClashes verifies that a lesson is only
+// explained as clashing when none of its classes fits, and that one the search dropped
+// although a class still fits is reported as pruned.
+func TestExplainUnassignedLessons_ClashOnlyWhenEveryClassClashes(t *testing.T) {
+	req := testRequest()
+	assigned := testSlot("MOD0|Tutorial", "01", 0, 10, 11, 0)
+	state := assignGroup(newEmptyState(req), "MOD0|Tutorial", []models.ModuleSlot{assigned}, req.ScoringWeights)
+	clashing := testSlot("MOD1|Tutorial", "01", 0, 10, 11, 0)
+	free := testSlot("MOD1|Tutorial", "02", 1, 10, 11, 0)
+
+	tests := []struct {
+		name        string
+		options     [][]models.ModuleSlot
+		truncated   bool
+		reason      string
+		clashesWith []string
+	}{
+		{name: "every class clashes", options: [][]models.ModuleSlot{{clashing}},
+			reason: models.UnassignedReasonClash, clashesWith: []string{"MOD0|Tutorial"}},
+		{name: "a class fits", options: [][]models.ModuleSlot{{clashing}, {free}},
+			reason: models.UnassignedReasonSearchPruned},
+		{name: "a class fits after the deadline", options: [][]models.ModuleSlot{{clashing}, {free}}, truncated: true,
+			reason: models.UnassignedReasonTimeLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lessonToSlots := map[string][][]models.ModuleSlot{
+				"MOD0|Tutorial": {{assigned}},
+				"MOD1|Tutorial": tt.options,
+			}
+			lessons := []string{"MOD0|Tutorial", "MOD1|Tutorial"}
+
+			unassigned := explainUnassignedLessons(
+				state,
+				tt.truncated,
+				lessonToSlots,
+				nil,
+				testDefaultSlots(lessons, lessonToSlots),
+				req,
+			)
+
+			if len(unassigned) != 1 || unassigned[0].LessonKey != "MOD1|Tutorial" {
+				t.Fatalf("Expected only MOD1|Tutorial unassigned, got %v", unassigned)
+			}
+			if unassigned[0].Reason != tt.reason || !slices.Equal(unassigned[0].ClashesWith, tt.clashesWith) {
+				t.Errorf("Expected reason %s clashing with %v, got %s clashing with %v",
+					tt.reason, tt.clashesWith, unassigned[0].Reason, unassigned[0].ClashesWith)
+			}
+		})
+	}
+}
+
+// TestLunchScore_ClampedToDayTimeWindow verifies that only the part of the lunch window
+// inside a day's own time window counts towards its lunch break.
+func TestLunchScore_ClampedToDayTimeWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		slot    models.ModuleSlot
+		windows map[string]models.TimeWindow
+		want    float64
+	}{
+		{name: "morning class", slot: testSlot("MOD0|Tutorial", "01", 4, 10, 11, 0),
+			want: constants.LunchBonus},
+		{name: "day ends before lunch", slot: testSlot("MOD0|Tutorial", "01", 4, 10, 11, 0),
+			windows: map[string]models.TimeWindow{"Friday": {LatestTime: "1200"}}, want: constants.NoLunchPenalty},
+		{name: "day ends an hour into lunch", slot: testSlot("MOD0|Tutorial", "01", 4, 10, 11, 0),
+			windows: map[string]models.TimeWindow{"Friday": {LatestTime: "1300"}}, want: constants.LunchBonus},
+		{name: "afternoon class", slot: testSlot("MOD0|Tutorial", "01", 0, 15, 16, 0),
+			want: constants.LunchBonus},
+		{name: "day starts late in lunch", slot: testSlot("MOD0|Tutorial", "01", 0, 15, 16, 0),
+			windows: map[string]models.TimeWindow{"Monday": {EarliestTime: "1330"}}, want: constants.NoLunchPenalty},
+		{name: "window of another day", slot: testSlot("MOD0|Tutorial", "01", 0, 15, 16, 0),
+			windows: map[string]models.TimeWindow{"Friday": {EarliestTime: "1330"}}, want: constants.LunchBonus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testRequest()
+			req.DayTimeWindows = tt.windows
+			if err := req.ParseOptimiserRequestFields(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := lunchScore([]models.ModuleSlot{tt.slot}, &req); got != tt.want {
+				t.Errorf("Expected lunch score %.0f, got %.0f", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestLunchScore_GlobalTimeWindowUnchanged verifies that a global earliestTime or latestTime
+// inside the lunch window leaves the lunch score as it is with a wide window: the user can
+// still have lunch off campus before the first class or after the last.
+func TestLunchScore_GlobalTimeWindowUnchanged(t *testing.T) {
+	days := [][]models.ModuleSlot{
+		{testSlot("MOD0|Tutorial", "01", 0, 10, 11, 0)},
+		{testSlot("MOD0|Tutorial", "01", 0, 15, 16, 0)},
+		{testSlot("MOD0|Tutorial", "01", 0, 11, 12, 0), testSlot("MOD0|Lecture", "1", 0, 13, 14, 0)},
+		{testSlot("MOD0|Tutorial", "01", 0, 12, 14, 0)},
+	}
+	for _, window := range [][2]string{{"0800", "1200"}, {"0800", "1300"}, {"1300", "2000"}, {"1330", "2000"}} {
+		t.Run(window[0]+"-"+window[1], func(t *testing.T) {
+			baseline := testRequest()
+			req := testRequest()
+			req.EarliestTime, req.LatestTime = window[0], window[1]
+			if err := req.ParseOptimiserRequestFields(); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, physicalSlots := range days {
+				want := lunchScore(physicalSlots, &baseline)
+				if got := lunchScore(physicalSlots, &req); got != want {
+					t.Errorf("Expected lunch score %.0f for day %d, got %.0f", want, i, got)
+				}
+			}
+		})
+	}
+}
+
+// TestBuildSearchSpace_RecordedClassKeepsRank verifies that a lower-ranked class carries its
+// rank penalty at classRankPenalty even when every slot of it is recorded, so the first
+// choice is preferred.
+func TestBuildSearchSpace_RecordedClassKeepsRank(t *testing.T) {
+	timetables := map[string][]models.ModuleSlot{"MOD0": {
+		{ClassNo: "1", Day: "Monday", StartTime: "1000", EndTime: "1200", LessonType: "Lecture", Venue: "LT19"},
+		{ClassNo: "01", Day: "Tuesday", StartTime: "1000", EndTime: "1100", LessonType: "Tutorial", Venue: "COM1-0201"},
+		{ClassNo: "02", Day: "Wednesday", StartTime: "1000", EndTime: "1100", LessonType: "Tutorial", Venue: "COM1-0201"},
+	}}
+
+	for _, rankPenalty := range []float64{constants.ClassRankPenalty, 2 * constants.ClassRankPenalty} {
+		t.Run(fmt.Sprint(rankPenalty), func(t *testing.T) {
+			req := testRequest()
+			req.Recordings = []string{"MOD0|Tutorial"}
+			req.ClassPreferences = map[string][]string{"MOD0|Tutorial": {"02"}}
+			if err := req.ParseOptimiserRequestFields(); err != nil {
+				t.Fatal(err)
+			}
+			req.ScoringWeights.ClassRankPenalty = rankPenalty
+
+			space, err := buildSearchSpace(timetables, &req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lecture := space.lessonToSlots["MOD0|Lecture"][0]
+			for _, group := range space.lessonToSlots["MOD0|Tutorial"] {
+				if !group[0].Recorded {
+					t.Fatalf("Expected every tutorial slot recorded, got %v", group)
+				}
+				state := assignGroup(newEmptyState(req), "MOD0|Lecture", lecture, req.ScoringWeights)
+				state = assignGroup(state, "MOD0|Tutorial", group, req.ScoringWeights)
+				want := rankPenalty
+				if group[0].ClassNo == "02" {
+					want = 0
+				}
+				if got := scoreBreakdown(state, req).Total[ScorerSlotPenalty]; got != want {
+					t.Errorf("Expected class %s to cost %.0f, got %.0f", group[0].ClassNo, want, got)
+				}
+			}
+
+			result := searchTimetables(context.Background(), space.lessons, space.lessonToSlots, req)
+			if got := result.states[0].Assignments["MOD0|Tutorial"]; got != "02" {
+				t.Errorf("Expected the first choice 02, got %s", got)
+			}
+		})
+	}
+}
+
+// TestBuildSearchSpace_RecordedClassMustExist records a class the module does not have, which
+// must be rejected rather than record nothing.
+func TestBuildSearchSpace_RecordedClassMustExist(t *testing.T) {
+	timetables := map[string][]models.ModuleSlot{"MOD0": {
+		{ClassNo: "1", Day: "Monday", StartTime: "1000", EndTime: "1200", LessonType: "Lecture", Venue: "LT19"},
+	}}
+
+	for recording, wantErr := range map[string]bool{"MOD0|Lecture|1": false, "MOD0|Lecture|9": true} {
+		req := testRequest()
+		req.Recordings = []string{recording}
+		if err := req.ParseOptimiserRequestFields(); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := buildSearchSpace(timetables, &req)
+		var solveErr *models.SolveError
+		if gotErr := errors.As(err, &solveErr) && solveErr.Code == http.StatusBadRequest; gotErr != wantErr {
+			t.Errorf("Expected a 400 error for %s: %v, got %v", recording, wantErr, err)
+		}
+	}
+}
+
+// TestChooseModules_PicksBestOfManyCombinations screens more combinations than are listed, so
+// some are skipped by their bound, and verifies that the best one is still chosen and the
+// runners-up are the next best, in order.
+func TestChooseModules_PicksBestOfManyCombinations(t *testing.T) {
+	// Every candidate adds a tutorial to the Monday of a lecture from 0800 to 0900. OPT0 follows
+	// it, and OPT1 to OPT3 leave ever longer gaps. The rest take the lunch break, which
+	// their bounds already count, so they are skipped once the others fill the listed four.
+	timetables := map[string][]models.ModuleSlot{"MOD0": {
+		{ClassNo: "1", Day: "Monday", StartTime: "0800", EndTime: "0900", LessonType: "Lecture", Venue: "COM1-0201"},
+	}}
+	tutorials := [][2]string{{"0900", "1000"}, {"1200", "1300"}, {"1300", "1400"}, {"1400", "1500"}}
+	for range 3 {
+		tutorials = append(tutorials, [2]string{"1100", "1500"})
+	}
+	req := testRequest()
+	for i := len(tutorials) - 1; i >= 0; i-- {
+		module := fmt.Sprintf("OPT%d", i)
+		req.OptionalModules = append(req.OptionalModules, module)
+		timetables[module] = []models.ModuleSlot{{
+			ClassNo:    "01",
+			Day:        "Monday",
+			StartTime:  tutorials[i][0],
+			EndTime:    tutorials[i][1],
+			LessonType: "Tutorial",
+			Venue:      "COM1-0201",
+		}}
+	}
+	req.ModuleCount = 2
+	if err := req.ParseOptimiserRequestFields(); err != nil {
+		t.Fatal(err)
+	}
+
+	choice, err := chooseModules(context.Background(), timetables, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(choice.chosen, []string{"OPT0"}) {
+		t.Errorf("Expected OPT0 chosen, got %v", choice.chosen)
+	}
+	var runnersUp []string
+	for _, combination := range choice.runnersUp {
+		if !combination.Complete {
+			t.Errorf("Expected every runner-up complete, got %v", combination)
+		}
+		runnersUp = append(runnersUp, combination.Modules...)
+	}
+	if want := []string{"OPT1", "OPT2", "OPT3"}; !slices.Equal(runnersUp, want) {
+		t.Errorf("Expected runners-up %v, got %v", want, runnersUp)
+	}
+}
+
+// TestScoreBreakdown_WeekScoring verifies that weekScoring scores lunch and consecutive hours
+// in each teaching week: labs in alternate weeks are no longer one block, and a lesson in
+// some weeks only no longer costs lunch in every week.
+func TestScoreBreakdown_WeekScoring(t *testing.T) {
+	inWeeks := func(slot models.ModuleSlot, mask uint64) models.ModuleSlot {
+		slot.WeekMask = mask
+		return slot
+	}
+	type components struct{ lunch, consecutiveHours float64 }
+	const rate = constants.ConsecutiveHoursPenaltyRate
+	tests := []struct {
+		name          string
+		teachingWeeks int
+		slots         []models.ModuleSlot
+		want          map[string]components
+	}{
+		{
+			name:          "labs in alternate weeks",
+			teachingWeeks: 6,
+			slots: []models.ModuleSlot{
+				inWeeks(testSlot("MOD0|Laboratory", "01", 0, 10, 12, 0), 0b010101),
+				inWeeks(testSlot("MOD1|Laboratory", "01", 0, 12, 14, 0), 0b101010),
+			},
+			want: map[string]components{
+				"":                      {lunch: constants.NoLunchPenalty, consecutiveHours: rate},
+				models.WeekScoringMean:  {lunch: 0, consecutiveHours: 0},
+				models.WeekScoringWorst: {lunch: constants.NoLunchPenalty, consecutiveHours: 0},
+			},
+		},
+		{
+			name:          "tutorial in weeks 3 to 6",
+			teachingWeeks: 13,
+			slots: []models.ModuleSlot{
+				testSlot("MOD0|Lecture", "1", 0, 10, 12, 0),
+				inWeeks(testSlot("MOD0|Tutorial", "01", 0, 12, 14, 0), 0b111100),
+			},
+			want: map[string]components{
+				"": {lunch: constants.NoLunchPenalty, consecutiveHours: rate},
+				models.WeekScoringMean: {
+					lunch:            (9*constants.LunchBonus + 4*constants.NoLunchPenalty) / 13,
+					consecutiveHours: 4.0 * rate / 13,
+				},
+				models.WeekScoringWorst: {lunch: constants.NoLunchPenalty, consecutiveHours: rate},
+			},
+		},
+	}
+	for _, tt := range tests {
+		for weekScoring, want := range tt.want {
+			t.Run(tt.name+"/"+cmp.Or(weekScoring, "none"), func(t *testing.T) {
+				req := testRequest()
+				req.WeekScoring = weekScoring
+				for week := 1; week <= tt.teachingWeeks; week++ {
+					req.TeachingWeeks = append(req.TeachingWeeks, week)
+				}
+				state := newEmptyState(req)
+				for _, slot := range tt.slots {
+					state = assignGroup(state, slot.LessonKey, []models.ModuleSlot{slot}, req.ScoringWeights)
+				}
+
+				breakdown := scoreBreakdown(state, req)
+				got := components{
+					lunch:            breakdown.Total[ScorerLunch],
+					consecutiveHours: breakdown.Total[ScorerConsecutiveHours],
+				}
+				if math.Abs(got.lunch-want.lunch) > 1e-9 || math.Abs(got.consecutiveHours-want.consecutiveHours) > 1e-9 {
+					t.Errorf("Expected %+v, got %+v", want, got)
+				}
+			})
+		}
+	}
+}
+
+// TestWeekGroups_KeptUpToDate verifies that the week groups kept on a state as lessons are
+// assigned and removed are the groups of its timetable built afresh, so that removing the
+// only lesson held in some weeks leaves no week views.
+func TestWeekGroups_KeptUpToDate(t *testing.T) {
+	inWeeks := func(slot models.ModuleSlot, mask uint64) models.ModuleSlot {
+		slot.WeekMask = mask
+		return slot
+	}
+	// lessonsByMask lists the lessons of each group by day, keyed by the group's weeks
+	lessonsByMask := func(groups []models.WeekGroup) map[uint64]string {
+		lessons := make(map[uint64]string, len(groups))
+		for _, group := range groups {
+			lessons[group.Mask] = fmt.Sprint(group.DaySlots)
+		}
+		return lessons
+	}
+	req := testRequest()
+	req.WeekScoring = models.WeekScoringMean
+	req.TeachingWeeks = []int{1, 2, 3, 4, 5, 6}
+	const allWeeks = 0b111111
+	slots := []models.ModuleSlot{
+		testSlot("MOD0|Lecture", "1", 0, 9, 10, 0),
+		inWeeks(testSlot("MOD0|Laboratory", "01", 0, 10, 12, 0), 0b010101),
+		inWeeks(testSlot("MOD1|Tutorial", "01", 0, 12, 13, 0), 0b000011),
+		inWeeks(testSlot("MOD1|Laboratory", "01", 1, 12, 14, 0), 0b111100),
+	}
+
+	state := newEmptyState(req)
+	for _, slot := range slots {
+		state = assignGroup(state, slot.LessonKey, []models.ModuleSlot{slot}, req.ScoringWeights)
+		want := lessonsByMask(newWeekGroups(allWeeks, state.DaySlots))
+		if got := lessonsByMask(state.WeekGroups); !maps.Equal(got, want) {
+			t.Fatalf("After assigning %s, expected groups %v, got %v", slot.LessonKey, want, got)
+		}
+	}
+	if got := len(state.WeekGroups); got != 4 {
+		t.Errorf("Expected 4 week groups, got %d", got)
+	}
+
+	for _, lessonKey := range []string{"MOD1|Tutorial", "MOD0|Laboratory", "MOD1|Laboratory"} {
+		state = removeLesson(state, lessonKey, req.ScoringWeights)
+		want := lessonsByMask(newWeekGroups(allWeeks, state.DaySlots))
+		if got := lessonsByMask(state.WeekGroups); !maps.Equal(got, want) {
+			t.Fatalf("After removing %s, expected groups %v, got %v", lessonKey, want, got)
+		}
+	}
+	if views := newScoreInput(state, req).Weeks; views != nil {
+		t.Errorf("Expected no week views with every lesson in every week, got %d", len(views))
+	}
+}
+
+// TestScoreBreakdown_Commute verifies that the commute home is measured from the lesson that ends
+// last, even when it starts first, and that with weekScoring a lesson held in some weeks
+// only adds to the commute of those weeks alone.
+func TestScoreBreakdown_Commute(t *testing.T) {
+	inWeeks := func(slot models.ModuleSlot, mask uint64) models.ModuleSlot {
+		slot.WeekMask = mask
+		return slot
+	}
+	home := testSlot("MOD0|Lecture", "1", 0, 9, 10, 0).Coordinates
+	far := testSlot("MOD0|Lecture", "1", 0, 9, 10, 0.01).Coordinates
+	km := distanceKm(home, far)
+	const rate = constants.CommutePenaltyRate
+	tests := []struct {
+		name        string
+		weekScoring string
+		slots       []models.ModuleSlot
+		want        float64
+	}{
+		{
+			name: "nested lesson",
+			slots: []models.ModuleSlot{
+				testSlot("MOD0|Lecture", "1", 0, 9, 13, 0.01),
+				testSlot("MOD1|Tutorial", "01", 0, 10, 11, 0),
+			},
+			want: 2 * rate * km,
+		},
+		{
+			name: "lesson in week 1 of 4",
+			slots: []models.ModuleSlot{
+				testSlot("MOD0|Lecture", "1", 0, 9, 10, 0),
+				inWeeks(testSlot("MOD1|Tutorial", "01", 0, 11, 12, 0.01), 0b0001),
+			},
+			want: rate * km,
+		},
+		{
+			name:        "lesson in week 1 of 4, mean",
+			weekScoring: models.WeekScoringMean,
+			slots: []models.ModuleSlot{
+				testSlot("MOD0|Lecture", "1", 0, 9, 10, 0),
+				inWeeks(testSlot("MOD1|Tutorial", "01", 0, 11, 12, 0.01), 0b0001),
+			},
+			want: rate * km / 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := testRequest()
+			req.WeekScoring = tt.weekScoring
+			req.TeachingWeeks = []int{1, 2, 3, 4}
+			req.HomeLocation = &models.HomeLocation{Coordinates: &home}
+			req.HomeCoordinates = home
+			state := newEmptyState(req)
+			for _, slot := range tt.slots {
+				state = assignGroup(state, slot.LessonKey, []models.ModuleSlot{slot}, req.ScoringWeights)
+			}
+
+			if got := scoreBreakdown(state, req).Total[ScorerCommute]; math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Expected commute %.4f, got %.4f", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestHasConflict_WeekRanges verifies that lessons on the dates of WeekRanges only clash when
+// they share a calendar week, and that calendar weeks are never compared with teaching weeks.
+func TestHasConflict_WeekRanges(t *testing.T) {
+	inWeeks := func(slot models.ModuleSlot, calendarWeeks bool, weeks ...int) models.ModuleSlot {
+		slot.WeeksSet = make(map[int]struct{}, len(weeks))
+		for _, week := range weeks {
+			slot.WeeksSet[week] = struct{}{}
+		}
+		slot.CalendarWeeks = calendarWeeks
+		return slot
+	}
+	req := testRequest()
+	// Calendar weeks 2872 and 2874 hold 2025-01-13 and 2025-01-27
+	assigned := inWeeks(testSlot("MOD0|Laboratory", "01", 0, 10, 12, 0), true, 2872, 2874)
+	state := assignGroup(newEmptyState(req), "MOD0|Laboratory", []models.ModuleSlot{assigned}, req.ScoringWeights)
+
+	tests := []struct {
+		name  string
+		slot  models.ModuleSlot
+		clash bool
+	}{
+		{name: "disjoint dates", slot: inWeeks(testSlot("MOD1|Laboratory", "01", 0, 11, 13, 0), true, 2873, 2875)},
+		{name: "shared date", slot: inWeeks(testSlot("MOD1|Laboratory", "01", 0, 11, 13, 0), true, 2874), clash: true},
+		{name: "teaching weeks", slot: inWeeks(testSlot("MOD1|Laboratory", "01", 0, 11, 13, 0), false, 2), clash: true},
+		{name: "no overlap in time", slot: inWeeks(testSlot("MOD1|Laboratory", "01", 0, 12, 13, 0), true, 2874)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasConflict(state, []models.ModuleSlot{tt.slot}); got != tt.clash {
+				t.Errorf("Expected clash %v, got %v", tt.clash, got)
+			}
+			if got := canAssign(state, []models.ModuleSlot{tt.slot}, req); got == tt.clash {
+				t.Errorf("Expected canAssign %v, got %v", !tt.clash, got)
+			}
+		})
+	}
+}
+
+// TestBeamSearch_ParallelMatchesSequential verifies that expanding the beam with several
+// workers gives exactly the beam a single worker does, in the same order.
+func TestBeamSearch_ParallelMatchesSequential(t *testing.T) {
+	lessons, lessonToSlots := testSpace(10, 8)
+	req := testRequest()
+	const beamWidth = 50 * constants.MinStatesPerBeamWorker
+
+	solve := func(procs int) []models.TimetableState {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		if workers := beamWorkers(beamWidth); (procs == 1) != (workers == 1) {
+			t.Fatalf("Expected a full beam to be expanded by %d workers, got %d", min(procs, constants.MaxBeamWorkers), workers)
+		}
+		beam, truncated := beamSearch(context.Background(), lessons, lessonToSlots, beamWidth, constants.BranchingFactor, req)
+		if truncated {
+			t.Fatal("Expected the beam search to finish")
+		}
+		return beam
+	}
+	sequential, parallel := solve(1), solve(8)
+
+	if len(sequential) != len(parallel) {
+		t.Fatalf("Expected %d states in parallel, got %d", len(sequential), len(parallel))
+	}
+	for i := range sequential {
+		if sequential[i].Score != parallel[i].Score || !maps.Equal(sequential[i].Assignments, parallel[i].Assignments) {
+			t.Fatalf("Beams differ at state %d: sequential %v (%v), parallel %v (%v)", i,
+				sequential[i].Assignments, sequential[i].Score, parallel[i].Assignments, parallel[i].Score)
+		}
+	}
+}
+
+// TestRefineTimetable_ImprovementIsScoreChange verifies that the reported improvement is the
+// score decrease of the refined timetable, also when moving a lesson frees the slot of a
+// lesson beam search skipped and refinement assigns it.
+func TestRefineTimetable_ImprovementIsScoreChange(t *testing.T) {
+	req := testRequest()
+	penalised := testSlot("MOD0|Tutorial", "01", 0, 10, 11, 0)
+	penalised.Penalty = 100
+	lessonToSlots := map[string][][]models.ModuleSlot{
+		"MOD0|Tutorial": {{penalised}, {testSlot("MOD0|Tutorial", "02", 1, 10, 11, 0)}},
+		"MOD1|Tutorial": {{testSlot("MOD1|Tutorial", "01", 0, 10, 11, 0)}},
+	}
+
+	tests := []struct {
+		name          string
+		lessons       []string
+		lessonsGained int
+	}{
+		{name: "swap only", lessons: []string{"MOD0|Tutorial"}, lessonsGained: 0},
+		{name: "swap frees a skipped lesson", lessons: []string{"MOD0|Tutorial", "MOD1|Tutorial"}, lessonsGained: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// MOD1 clashes with the penalised class, as if beam search had to skip it
+			state := assignGroup(newEmptyState(req), "MOD0|Tutorial", []models.ModuleSlot{penalised}, req.ScoringWeights)
+			state.Score = scoreTimetableState(state, req)
+
+			refined, improvement, lessonsGained := refineTimetable(context.Background(), state, tt.lessons, lessonToSlots, req)
+
+			if refined.Assignments["MOD0|Tutorial"] != "02" {
+				t.Fatalf("Expected MOD0 moved off the penalised class, got %v", refined.Assignments)
+			}
+			if lessonsGained != tt.lessonsGained || len(refined.Assignments)-len(state.Assignments) != lessonsGained {
+				t.Errorf("Expected %d lessons gained, got %d (%v)", tt.lessonsGained, lessonsGained, refined.Assignments)
+			}
+			if rescored := scoreTimetableState(refined, req); refined.Score != rescored {
+				t.Errorf("Expected the refined score %.2f to match its rescore %.2f", refined.Score, rescored)
+			}
+			if improvement != state.Score-refined.Score {
+				t.Errorf("Expected an improvement of %.2f (%.2f - %.2f), got %.2f",
+					state.Score-refined.Score, state.Score, refined.Score, improvement)
+			}
+		})
+	}
+}
+
+// helpers
+
+// countdownContext is a context that is done once Err has been called remaining times, so
+// a search can be cut off at an exact point.
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining <= 0 {
+		return context.Canceled
+	}
+	c.remaining--
+	return nil
+}
+
+// testRequest returns a parsed request with the default scoring weights.
+func testRequest() models.OptimiserRequest {
+	req := models.OptimiserRequest{
+		Modules:             []string{"MOD0"},
+		EarliestTime:        "0800",
+		LatestTime:          "2000",
+		LunchStart:          "1200",
+		LunchEnd:            "1400",
+		MaxConsecutiveHours: 3,
+	}
+	if err := req.ParseOptimiserRequestFields(); err != nil {
+		panic(err)
+	}
+	req.ScoringWeights = constants.DefaultScoringWeights
+	return req
+}
+
+// testSlot returns a parsed slot of lessonKey's class classNo on day d (0 for Monday) from
+// the hour start to the hour end, at a venue offset east of the origin by x degrees.
+func testSlot(lessonKey string, classNo string, d int, start int, end int, x float64) models.ModuleSlot {
+	slot := models.ModuleSlot{
+		ClassNo:     classNo,
+		Day:         models.DayName(d),
+		StartTime:   fmt.Sprintf("%02d00", start),
+		EndTime:     fmt.Sprintf("%02d00", end),
+		LessonType:  strings.SplitN(lessonKey, "|", 2)[1],
+		Venue:       "COM1",
+		Coordinates: models.Coordinates{X: 103.77 + x, Y: 1.29},
+		WeekMask:    ^uint64(0),
+	}
+	if err := slot.ParseModuleSlotFields(lessonKey); err != nil {
+		panic(err)
+	}
+	return slot
+}
+
+// testSpace returns nLessons tutorials of nClasses single-slot classes each, spread over the
+// weekdays by a fixed pseudo-random sequence, ordered like buildSearchSpace orders lessons.
+func testSpace(nLessons int, nClasses int) ([]string, map[string][][]models.ModuleSlot) {
+	seed := 7
+	next := func(n int) int {
+		seed = (seed*1103515245 + 12345) % (1 << 31)
+		return seed % n
+	}
+	lessons := make([]string, 0, nLessons)
+	lessonToSlots := make(map[string][][]models.ModuleSlot, nLessons)
+	for l := range nLessons {
+		lessonKey := fmt.Sprintf("MOD%d|Tutorial", l)
+		lessons = append(lessons, lessonKey)
+		for c := range nClasses {
+			start := 8 + next(10)
+			slot := testSlot(lessonKey, fmt.Sprintf("%02d", c), next(5), start, start+1+next(2), float64(next(100))/10000)
+			lessonToSlots[lessonKey] = append(lessonToSlots[lessonKey], []models.ModuleSlot{slot})
+		}
+	}
+	return lessons, lessonToSlots
+}
+
+// testDefaultSlots returns the first class of every lesson as its default slots.
+func testDefaultSlots(lessons []string, lessonToSlots map[string][][]models.ModuleSlot) models.ModuleDefaultSlotsMap {
+	defaultSlots := make(models.ModuleDefaultSlotsMap)
+	for _, lessonKey := range lessons {
+		module, lessonType, _ := strings.Cut(lessonKey, "|")
+		if defaultSlots[module] == nil {
+			defaultSlots[module] = make(map[models.LessonType][]models.ModuleSlot)
+		}
+		defaultSlots[module][lessonType] = lessonToSlots[lessonKey][0]
+	}
+	return defaultSlots
+}
@@ -0,0 +1,138 @@
+package solver
+
+import (
+	"sort"
+	"strings"
+
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// explainUnassignedLessons returns, sorted by lessonKey, every lesson in defaultSlots that
+// has no class in state, together with the reason it could not be assigned:
+//   - filtered: mergeAndFilterModuleSlots removed every class of the lesson, and
+//     filteredReasons says which hard constraints did so
+//   - clash: every remaining class clashes with a lesson assigned in state
+//   - maxHoursPerDay: every class that does not clash would take a day over a hard
+//     maxHoursPerDay
+//   - travelTime: every class that neither clashes nor breaks maxHoursPerDay leaves too
+//     little time to walk to or from an assigned lesson
+//   - timeLimit: the search was truncated before the lesson was reached
+//   - searchPruned: a class still fits, but the search did not keep a timetable with it
+func explainUnassignedLessons(
+	state models.TimetableState,
+	truncated bool,
+	lessonToSlots map[string][][]models.ModuleSlot,
+	filteredReasons map[string][]string,
+	defaultSlots models.ModuleDefaultSlotsMap,
+	optimiserRequest models.OptimiserRequest,
+) []models.UnassignedLesson {
+	unassigned := make([]models.UnassignedLesson, 0)
+	for moduleCode, lessonTypeMap := range defaultSlots {
+		for lessonType := range lessonTypeMap {
+			lessonKey := strings.ToUpper(moduleCode) + "|" + lessonType
+			if state.Assignments[lessonKey] != "" {
+				continue
+			}
+
+			options := filterValidGroups(lessonToSlots[lessonKey], len(lessonToSlots[lessonKey]))
+			if len(options) == 0 {
+				unassigned = append(unassigned, models.UnassignedLesson{
+					LessonKey:  lessonKey,
+					Reason:     models.UnassignedReasonFiltered,
+					FilteredBy: filteredReasons[lessonKey],
+				})
+				continue
+			}
+
+			clashesWith, allClash := clashingLessonKeys(state, options)
+			if !allClash && !anyAssignable(state, options, optimiserRequest) {
+				reason := models.UnassignedReasonMaxHoursPerDay
+				if anyWithinDailyHours(state, options, optimiserRequest) {
+					reason = models.UnassignedReasonTravelTime
+				}
+				unassigned = append(unassigned, models.UnassignedLesson{
+					LessonKey: lessonKey,
+					Reason:    reason,
+				})
+				continue
+			}
+			if !allClash {
+				reason := models.UnassignedReasonSearchPruned
+				if truncated {
+					reason = models.UnassignedReasonTimeLimit
+				}
+				unassigned = append(unassigned, models.UnassignedLesson{
+					LessonKey: lessonKey,
+					Reason:    reason,
+				})
+				continue
+			}
+			unassigned = append(unassigned, models.UnassignedLesson{
+				LessonKey:   lessonKey,
+				Reason:      models.UnassignedReasonClash,
+				ClashesWith: clashesWith,
+			})
+		}
+	}
+	sort.Slice(unassigned, func(i, j int) bool {
+		return unassigned[i].LessonKey < unassigned[j].LessonKey
+	})
+	return unassigned
+}
+
+// anyAssignable reports whether any of the options passes canAssign against state.
+func anyAssignable(
+	state models.TimetableState,
+	options [][]models.ModuleSlot,
+	optimiserRequest models.OptimiserRequest,
+) bool {
+	for _, group := range options {
+		if canAssign(state, group, optimiserRequest) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyWithinDailyHours reports whether any of the options neither clashes with state nor
+// takes a day over a hard maxHoursPerDay.
+func anyWithinDailyHours(
+	state models.TimetableState,
+	options [][]models.ModuleSlot,
+	optimiserRequest models.OptimiserRequest,
+) bool {
+	for _, group := range options {
+		if !hasConflict(state, group) && !exceedsDailyHours(state, group, optimiserRequest) {
+			return true
+		}
+	}
+	return false
+}
+
+// clashingLessonKeys returns the sorted lessonKeys of the lessons in state that clash with
+// at least one of the options, and whether every option clashes with something.
+func clashingLessonKeys(state models.TimetableState, options [][]models.ModuleSlot) ([]string, bool) {
+	clashing := make(map[string]struct{})
+	allClash := true
+	for _, group := range options {
+		groupClashes := false
+		for _, newSlot := range group {
+			for _, oldSlot := range state.DaySlots[newSlot.DayIndex] {
+				if slotsClash(newSlot, oldSlot) {
+					clashing[oldSlot.LessonKey] = struct{}{}
+					groupClashes = true
+				}
+			}
+		}
+		if !groupClashes {
+			allClash = false
+		}
+	}
+
+	lessonKeys := make([]string, 0, len(clashing))
+	for lessonKey := range clashing {
+		lessonKeys = append(lessonKeys, lessonKey)
+	}
+	sort.Strings(lessonKeys)
+	return lessonKeys, allClash
+}
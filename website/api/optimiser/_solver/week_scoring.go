@@ -0,0 +1,155 @@
+package solver
+
+import (
+	"math"
+	"math/bits"
+	"slices"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// WeekView is the timetable of a group of teaching weeks that hold the same lessons, as
+// scored with weekScoring.
+type WeekView struct {
+	DaySlots      [constants.DaysPerWeek][]models.ModuleSlot // The slots held in these weeks
+	PhysicalSlots [constants.DaysPerWeek][]models.ModuleSlot // DaySlots without recorded lessons
+	Weeks         int                                        // Number of teaching weeks in the group
+	Weight        float64                                    // Share of the view in the week-aware scores
+}
+
+// weekAwareScorers are the score components that score each WeekView, rather than every
+// lesson as if it ran every week.
+//
+//nolint:gochecknoglobals // a subset of the scorers registry
+var weekAwareScorers = []Scorer{
+	lunchScorer{},
+	gapScorer{},
+	consecutiveHoursScorer{},
+	distanceScorer{},
+	commuteScorer{},
+}
+
+// newWeekViews splits the timetable of in by teaching week when the request sets
+// weekScoring, with one view for each of the state's WeekGroups. With WeekScoringMean each
+// view is weighted by its share of the teaching weeks; with WeekScoringWorst the view that
+// scores highest on the week-aware components has all the weight, ties going to the view
+// holding the earliest week.
+//
+// It returns nil without weekScoring, or when every lesson of the timetable runs in every
+// teaching week, since each week then scores the same as the whole timetable.
+func newWeekViews(in ScoreInput) []WeekView {
+	groups := in.State.WeekGroups
+	if len(groups) < 2 {
+		return nil
+	}
+
+	weeks := bits.OnesCount64(weekGroupsMask(groups))
+	views := make([]WeekView, len(groups))
+	for i := range groups {
+		view := &views[i]
+		view.Weeks = bits.OnesCount64(groups[i].Mask)
+		view.Weight = float64(view.Weeks) / float64(weeks)
+		view.DaySlots = groups[i].DaySlots
+		for d := 0; d < constants.DaysPerWeek; d++ {
+			view.PhysicalSlots[d] = getPhysicalSlots(view.DaySlots[d])
+		}
+	}
+
+	if in.OptimiserRequest.WeekScoring == models.WeekScoringWorst {
+		worst, worstScore := 0, math.Inf(-1)
+		for i := range views {
+			single := in
+			single.Weeks = []WeekView{views[i]}
+			single.Weeks[0].Weight = 1
+			var score float64
+			for _, scorer := range weekAwareScorers {
+				score += scorer.Score(single).Total()
+			}
+			earlier := bits.TrailingZeros64(groups[i].Mask) < bits.TrailingZeros64(groups[worst].Mask)
+			if score > worstScore || score == worstScore && earlier {
+				worst, worstScore = i, score
+			}
+		}
+		for i := range views {
+			views[i].Weight = 0
+		}
+		views[worst].Weight = 1
+	}
+	return views
+}
+
+// newWeekGroups returns the week groups of a timetable with daySlots, held in the teaching
+// weeks of mask.
+func newWeekGroups(mask uint64, daySlots [constants.DaysPerWeek][]models.ModuleSlot) []models.WeekGroup {
+	groups := []models.WeekGroup{{Mask: mask}}
+	for d := 0; d < constants.DaysPerWeek; d++ {
+		for i := range daySlots[d] {
+			groups = addToWeekGroups(groups, daySlots[d][i])
+		}
+	}
+	return groups
+}
+
+// addToWeekGroups returns a copy of groups with slot added to the groups of the teaching
+// weeks it is held in. A group holding slot in some of its weeks only is split in two
+// first. Only the day slices that change are reallocated, so the rest are shared with
+// groups.
+func addToWeekGroups(groups []models.WeekGroup, slot models.ModuleSlot) []models.WeekGroup {
+	added := make([]models.WeekGroup, 0, len(groups)+1)
+	for _, group := range groups {
+		held := group.Mask & slot.WeekMask
+		if held == 0 {
+			added = append(added, group)
+			continue
+		}
+		if held != group.Mask {
+			without := group
+			without.Mask &^= held
+			added = append(added, without)
+			group.Mask = held
+		}
+		// Clipped so that the insertion reallocates rather than writing to a shared array
+		d := slot.DayIndex
+		group.DaySlots[d] = insertSlotSorted(slices.Clip(group.DaySlots[d]), slot)
+		added = append(added, group)
+	}
+	return added
+}
+
+// weekGroupsMask returns the teaching weeks held by any of groups.
+func weekGroupsMask(groups []models.WeekGroup) uint64 {
+	var mask uint64
+	for i := range groups {
+		mask |= groups[i].Mask
+	}
+	return mask
+}
+
+// weekly returns the score dayScore gives day d, aggregated over the week views by their
+// weights, or dayScore of the whole day without week views.
+func (in ScoreInput) weekly(d int, dayScore func(daySlots, physicalSlots []models.ModuleSlot) float64) float64 {
+	if in.Weeks == nil {
+		return dayScore(in.State.DaySlots[d], in.PhysicalSlots[d])
+	}
+	var score float64
+	for i := range in.Weeks {
+		if view := &in.Weeks[i]; view.Weight != 0 {
+			score += view.Weight * dayScore(view.DaySlots[d], view.PhysicalSlots[d])
+		}
+	}
+	return score
+}
+
+// bestWeek returns the lowest score dayScore gives day d in any week view, or dayScore of
+// the whole day without week views.
+func (in ScoreInput) bestWeek(d int, dayScore func(daySlots, physicalSlots []models.ModuleSlot) float64) float64 {
+	if in.Weeks == nil {
+		return dayScore(in.State.DaySlots[d], in.PhysicalSlots[d])
+	}
+	best := math.Inf(1)
+	for i := range in.Weeks {
+		best = min(best, dayScore(in.Weeks[i].DaySlots[d], in.Weeks[i].PhysicalSlots[d]))
+	}
+	return best
+}
@@ -0,0 +1,69 @@
+package solver
+
+import (
+	"fmt"
+
+	constants "github.com/nusmodifications/nusmods/website/api/optimiser/_constants"
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// resolveScoringWeights applies a request's weight overrides to the default weights.
+//
+// Every override is bounded so that bonuses stay bonuses and penalties stay penalties:
+// LunchBonus must be <= 0 and every other weight >= 0, which keeps the exact search's
+// lower bound admissible. MaxWalkDistance must be positive since the walking penalty
+// divides by it.
+func resolveScoringWeights(overrides models.WeightOverrides) (models.ScoringWeights, error) {
+	weights := constants.DefaultScoringWeights
+
+	if overrides.LunchBonus != nil {
+		if *overrides.LunchBonus < -constants.MaxScoringWeight || *overrides.LunchBonus > 0 {
+			return weights, fmt.Errorf("invalid weights.lunchBonus: %v", *overrides.LunchBonus)
+		}
+		weights.LunchBonus = *overrides.LunchBonus
+	}
+	if overrides.GapPenaltyThreshold != nil {
+		if *overrides.GapPenaltyThreshold < 0 || *overrides.GapPenaltyThreshold > constants.MaxGapPenaltyThreshold {
+			return weights, fmt.Errorf("invalid weights.gapPenaltyThreshold: %d", *overrides.GapPenaltyThreshold)
+		}
+		weights.GapPenaltyThreshold = *overrides.GapPenaltyThreshold
+	}
+	if overrides.MaxWalkDistance != nil {
+		if *overrides.MaxWalkDistance < constants.MinWalkDistance ||
+			*overrides.MaxWalkDistance > constants.MaxWalkDistanceOverride {
+			return weights, fmt.Errorf("invalid weights.maxWalkDistance: %v", *overrides.MaxWalkDistance)
+		}
+		weights.MaxWalkDistance = *overrides.MaxWalkDistance
+	}
+
+	penalties := []struct {
+		name     string
+		override *float64
+		weight   *float64
+	}{
+		{"noLunchPenalty", overrides.NoLunchPenalty, &weights.NoLunchPenalty},
+		{"gapPenaltyRate", overrides.GapPenaltyRate, &weights.GapPenaltyRate},
+		{"consecutiveHoursPenaltyRate", overrides.ConsecutiveHoursPenaltyRate, &weights.ConsecutiveHoursPenaltyRate},
+		{"noVenuePenalty", overrides.NoVenuePenalty, &weights.NoVenuePenalty},
+		{"campusDayPenalty", overrides.CampusDayPenalty, &weights.CampusDayPenalty},
+		{"dailyHoursPenaltyRate", overrides.DailyHoursPenaltyRate, &weights.DailyHoursPenaltyRate},
+		{"balancePenaltyRate", overrides.BalancePenaltyRate, &weights.BalancePenaltyRate},
+		{"travelTimePenaltyRate", overrides.TravelTimePenaltyRate, &weights.TravelTimePenaltyRate},
+		{"commutePenaltyRate", overrides.CommutePenaltyRate, &weights.CommutePenaltyRate},
+		{"avoidedVenuePenalty", overrides.AvoidedVenuePenalty, &weights.AvoidedVenuePenalty},
+		{"unpreferredVenuePenalty", overrides.UnpreferredVenuePenalty, &weights.UnpreferredVenuePenalty},
+		{"classRankPenalty", overrides.ClassRankPenalty, &weights.ClassRankPenalty},
+		{"excludedWeekPenalty", overrides.ExcludedWeekPenalty, &weights.ExcludedWeekPenalty},
+	}
+	for _, penalty := range penalties {
+		if penalty.override == nil {
+			continue
+		}
+		if *penalty.override < 0 || *penalty.override > constants.MaxScoringWeight {
+			return weights, fmt.Errorf("invalid weights.%s: %v", penalty.name, *penalty.override)
+		}
+		*penalty.weight = *penalty.override
+	}
+
+	return weights, nil
+}
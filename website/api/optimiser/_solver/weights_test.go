@@ -0,0 +1,394 @@
+package solver
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
+)
+
+// TestScoreBreakdown_WeightsScaleComponents doubles each scoring weight in turn for a fixed
+// timetable that every component scores, and verifies that only the weight's own component
+// doubles.
+func TestScoreBreakdown_WeightsScaleComponents(t *testing.T) {
+	timetables := map[string][]models.ModuleSlot{"MOD0": {
+		{ClassNo: "1", Day: "Monday", StartTime: "0900", EndTime: "1100", LessonType: "Lecture", Venue: "LT19"},
+		{ClassNo: "01", Day: "Monday", StartTime: "1100", EndTime: "1300", LessonType: "Tutorial", Venue: "COM1-0201"},
+		{ClassNo: "02", Day: "Wednesday", StartTime: "1000", EndTime: "1100", LessonType: "Tutorial", Venue: "COM1-0201"},
+		{ClassNo: "01", Day: "Monday", StartTime: "1600", EndTime: "1700", LessonType: "Laboratory", Venue: "S16-0430"},
+	}}
+	classes := map[string]models.ClassNo{"MOD0|Lecture": "1", "MOD0|Tutorial": "01", "MOD0|Laboratory": "01"}
+
+	double := func(weight float64) *float64 {
+		weight *= 2
+		return &weight
+	}
+	defaults := testRequest().ScoringWeights
+	halfWalkDistance := defaults.MaxWalkDistance / 2
+	tests := []struct {
+		name      string
+		setup     func(req *models.OptimiserRequest)
+		weights   models.WeightOverrides
+		component string
+	}{
+		{
+			name: "lunch",
+			weights: models.WeightOverrides{
+				LunchBonus:     double(defaults.LunchBonus),
+				NoLunchPenalty: double(defaults.NoLunchPenalty),
+			},
+			component: ScorerLunch,
+		},
+		{
+			name:      "gap",
+			weights:   models.WeightOverrides{GapPenaltyRate: double(defaults.GapPenaltyRate)},
+			component: ScorerGap,
+		},
+		{
+			name:      "consecutive hours",
+			weights:   models.WeightOverrides{ConsecutiveHoursPenaltyRate: double(defaults.ConsecutiveHoursPenaltyRate)},
+			component: ScorerConsecutiveHours,
+		},
+		{
+			name: "distance",
+			weights: models.WeightOverrides{
+				MaxWalkDistance: &halfWalkDistance,
+				NoVenuePenalty:  double(defaults.NoVenuePenalty),
+			},
+			component: ScorerDistance,
+		},
+		{
+			name:      "campus days",
+			setup:     func(req *models.OptimiserRequest) { req.MinimiseCampusDays = true },
+			weights:   models.WeightOverrides{CampusDayPenalty: double(defaults.CampusDayPenalty)},
+			component: ScorerCampusDays,
+		},
+		{
+			name: "daily hours",
+			setup: func(req *models.OptimiserRequest) {
+				req.MaxHoursPerDay = 2
+				req.MaxHoursPerDaySoft = true
+			},
+			weights:   models.WeightOverrides{DailyHoursPenaltyRate: double(defaults.DailyHoursPenaltyRate)},
+			component: ScorerDailyHours,
+		},
+		{
+			name:      "balance",
+			setup:     func(req *models.OptimiserRequest) { req.BalanceDailyHours = true },
+			weights:   models.WeightOverrides{BalancePenaltyRate: double(defaults.BalancePenaltyRate)},
+			component: ScorerBalance,
+		},
+		{
+			name: "travel time",
+			setup: func(req *models.OptimiserRequest) {
+				req.WalkingSpeed = 1
+				req.TravelTimeSoft = true
+			},
+			weights:   models.WeightOverrides{TravelTimePenaltyRate: double(defaults.TravelTimePenaltyRate)},
+			component: ScorerTravelTime,
+		},
+		{
+			name:      "commute",
+			setup:     func(req *models.OptimiserRequest) { req.HomeLocation = &models.HomeLocation{Venue: "UT-AUD1"} },
+			weights:   models.WeightOverrides{CommutePenaltyRate: double(defaults.CommutePenaltyRate)},
+			component: ScorerCommute,
+		},
+		{
+			name:      "avoided venues",
+			setup:     func(req *models.OptimiserRequest) { req.AvoidedVenues = []string{"LT19"} },
+			weights:   models.WeightOverrides{AvoidedVenuePenalty: double(defaults.AvoidedVenuePenalty)},
+			component: ScorerSlotPenalty,
+		},
+		{
+			name:      "preferred venues",
+			setup:     func(req *models.OptimiserRequest) { req.PreferredVenues = []string{"UT-AUD1"} },
+			weights:   models.WeightOverrides{UnpreferredVenuePenalty: double(defaults.UnpreferredVenuePenalty)},
+			component: ScorerSlotPenalty,
+		},
+		{
+			name: "class preferences",
+			setup: func(req *models.OptimiserRequest) {
+				req.ClassPreferences = map[string][]string{"MOD0|Tutorial": {"02", "01"}}
+			},
+			weights:   models.WeightOverrides{ClassRankPenalty: double(defaults.ClassRankPenalty)},
+			component: ScorerSlotPenalty,
+		},
+		{
+			name: "excluded weeks",
+			setup: func(req *models.OptimiserRequest) {
+				req.ExcludedWeeks = []int{3}
+				req.ExcludedWeeksSoft = true
+			},
+			weights:   models.WeightOverrides{ExcludedWeekPenalty: double(defaults.ExcludedWeekPenalty)},
+			component: ScorerSlotPenalty,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breakdown := func(weights models.WeightOverrides) models.ScoreBreakdown {
+				req := testRequest()
+				if tt.setup != nil {
+					tt.setup(&req)
+				}
+				req.Weights = weights
+				parseTestRequest(t, &req)
+				state := assignClasses(t, timetables, &req, classes)
+				return scoreBreakdown(state, req)
+			}
+			base, scaled := breakdown(models.WeightOverrides{}), breakdown(tt.weights)
+
+			if base.Total[tt.component] == 0 {
+				t.Fatalf("Expected the timetable to have a %s component, got %v", tt.component, base.Total)
+			}
+			for component, total := range base.Total {
+				want := total
+				if component == tt.component {
+					want *= 2
+				}
+				if got := scaled.Total[component]; math.Abs(got-want) > 1e-6 {
+					t.Errorf("Expected %s to be %.4f, got %.4f", component, want, got)
+				}
+			}
+		})
+	}
+}
+
+// TestSearchTimetables_HigherWeightChangesTimetable lets each case's feature cost the
+// first-choice tutorial 01 less than the fixed penalty of tutorial 02 at the default weight,
+// and more once the weight is raised: the search must switch from 01 to 02.
+func TestSearchTimetables_HigherWeightChangesTimetable(t *testing.T) {
+	// The tutorial joins a lecture on Monday from 0900 to 1100 and a lab on Tuesday from 0900
+	// to 1000, all at the origin unless a case moves it
+	lecture := testSlot("MOD0|Lecture", "1", 0, 9, 11, 0)
+	lab := testSlot("MOD0|Laboratory", "1", 1, 9, 10, 0)
+	weight := func(weight float64) *float64 { return &weight }
+	tests := []struct {
+		name        string
+		setup       func(req *models.OptimiserRequest)
+		first       models.ModuleSlot
+		second      models.ModuleSlot
+		rankPenalty float64 // Penalty of tutorial 02
+		raised      models.WeightOverrides
+	}{
+		{
+			// A 1-hour gap over the threshold costs 100
+			name:        "gap rate",
+			first:       testSlot("MOD1|Tutorial", "01", 0, 14, 15, 0),
+			second:      testSlot("MOD1|Tutorial", "02", 0, 11, 12, 0),
+			rankPenalty: 150,
+			raised:      models.WeightOverrides{GapPenaltyRate: weight(400)},
+		},
+		{
+			// Wednesday on campus costs 500, less its lunch bonus of 300
+			name:        "campus days",
+			setup:       func(req *models.OptimiserRequest) { req.MinimiseCampusDays = true },
+			first:       testSlot("MOD1|Tutorial", "01", 2, 9, 10, 0),
+			second:      testSlot("MOD1|Tutorial", "02", 0, 11, 12, 0),
+			rankPenalty: 300,
+			raised:      models.WeightOverrides{CampusDayPenalty: weight(1000)},
+		},
+		{
+			// A third hour on Monday costs 100
+			name: "daily hours",
+			setup: func(req *models.OptimiserRequest) {
+				req.MaxHoursPerDay = 2
+				req.MaxHoursPerDaySoft = true
+			},
+			first:       testSlot("MOD1|Tutorial", "01", 0, 11, 12, 0),
+			second:      testSlot("MOD1|Tutorial", "02", 1, 10, 11, 0),
+			rankPenalty: 150,
+			raised:      models.WeightOverrides{DailyHoursPenaltyRate: weight(400)},
+		},
+		{
+			// 3 hours on Monday and 1 on Tuesday cost 100 more than 2 on each
+			name: "balance",
+			setup: func(req *models.OptimiserRequest) {
+				req.BalanceDailyHours = true
+				req.FreeDays = []string{"Wednesday", "Thursday", "Friday"}
+			},
+			first:       testSlot("MOD1|Tutorial", "01", 0, 11, 12, 0),
+			second:      testSlot("MOD1|Tutorial", "02", 1, 10, 11, 0),
+			rankPenalty: 150,
+			raised:      models.WeightOverrides{BalancePenaltyRate: weight(200)},
+		},
+		{
+			// About 110 m right after the lecture, 2.2 minutes short at 50 m/min: 111 for the
+			// travel time and 4 for the walk
+			name: "travel time",
+			setup: func(req *models.OptimiserRequest) {
+				req.WalkingSpeed = 50
+				req.TravelTimeSoft = true
+			},
+			first:       testSlot("MOD1|Tutorial", "01", 0, 11, 12, 0.001),
+			second:      testSlot("MOD1|Tutorial", "02", 0, 11, 12, 0),
+			rankPenalty: 200,
+			raised:      models.WeightOverrides{TravelTimePenaltyRate: weight(200)},
+		},
+		{
+			// About 1.1 km from home at the end of Monday: 22 for the commute and 44 for the walk
+			name: "commute",
+			setup: func(req *models.OptimiserRequest) {
+				req.HomeLocation = &models.HomeLocation{Coordinates: &lecture.Coordinates}
+				req.HomeCoordinates = lecture.Coordinates
+			},
+			first:       testSlot("MOD1|Tutorial", "01", 0, 11, 12, 0.01),
+			second:      testSlot("MOD1|Tutorial", "02", 0, 11, 12, 0),
+			rankPenalty: 150,
+			raised:      models.WeightOverrides{CommutePenaltyRate: weight(200)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			second := tt.second
+			second.Penalty = tt.rankPenalty
+			lessons := []string{"MOD0|Lecture", "MOD0|Laboratory", "MOD1|Tutorial"}
+			lessonToSlots := map[string][][]models.ModuleSlot{
+				"MOD0|Lecture":    {{lecture}},
+				"MOD0|Laboratory": {{lab}},
+				"MOD1|Tutorial":   {{tt.first}, {second}},
+			}
+
+			for _, run := range []struct {
+				weights models.WeightOverrides
+				want    models.ClassNo
+			}{{models.WeightOverrides{}, "01"}, {tt.raised, "02"}} {
+				req := testRequest()
+				if tt.setup != nil {
+					tt.setup(&req)
+				}
+				req.Weights = run.weights
+				parseTestRequest(t, &req)
+
+				result := searchTimetables(context.Background(), lessons, lessonToSlots, req)
+				if got := result.states[0].Assignments["MOD1|Tutorial"]; got != run.want {
+					t.Errorf("Expected tutorial %s with weights %+v, got %s", run.want, run.weights, got)
+				}
+			}
+		})
+	}
+}
+
+// TestBuildSearchSpace_HigherSlotPenaltyChangesTimetable is the same for the penalties
+// attached to slots when the search space is built: tutorial 02 costs its rank in
+// classPreferences, and only the first choice 01 is penalised by the case's feature.
+func TestBuildSearchSpace_HigherSlotPenaltyChangesTimetable(t *testing.T) {
+	allWeeks := map[int]struct{}{1: {}, 2: {}, 3: {}, 4: {}, 5: {}, 6: {}}
+	weeksWithout3 := map[int]struct{}{1: {}, 2: {}, 4: {}, 5: {}, 6: {}}
+	// The tutorials are alone on Tuesday, so only their own penalties differ
+	timetables := func(firstVenue string, secondVenue string) map[string][]models.ModuleSlot {
+		return map[string][]models.ModuleSlot{
+			"MOD0": {
+				{ClassNo: "1", Day: "Monday", StartTime: "0900", EndTime: "1100", LessonType: "Lecture", Venue: "LT19"},
+			},
+			"MOD1": {
+				{
+					ClassNo: "01", Day: "Tuesday", StartTime: "0900", EndTime: "1000", LessonType: "Tutorial",
+					Venue: firstVenue, WeeksSet: allWeeks,
+				},
+				{
+					ClassNo: "02", Day: "Tuesday", StartTime: "1500", EndTime: "1600", LessonType: "Tutorial",
+					Venue: secondVenue, WeeksSet: weeksWithout3,
+				},
+			},
+		}
+	}
+	weight := func(weight float64) *float64 { return &weight }
+	tests := []struct {
+		name       string
+		timetables map[string][]models.ModuleSlot
+		setup      func(req *models.OptimiserRequest)
+		weights    models.WeightOverrides // Of both solves
+		raised     models.WeightOverrides
+	}{
+		{
+			name:       "avoided venues",
+			timetables: timetables("COM1-0201", "LT17"),
+			setup:      func(req *models.OptimiserRequest) { req.AvoidedVenues = []string{"COM1"} },
+			weights:    models.WeightOverrides{ClassRankPenalty: weight(300)},
+			raised:     models.WeightOverrides{ClassRankPenalty: weight(300), AvoidedVenuePenalty: weight(400)},
+		},
+		{
+			name:       "preferred venues",
+			timetables: timetables("COM1-0201", "LT17"),
+			setup:      func(req *models.OptimiserRequest) { req.PreferredVenues = []string{"LT17"} },
+			raised:     models.WeightOverrides{UnpreferredVenuePenalty: weight(200)},
+		},
+		{
+			name:       "excluded weeks",
+			timetables: timetables("COM1-0201", "COM1-0201"),
+			setup: func(req *models.OptimiserRequest) {
+				req.ExcludedWeeks = []int{3}
+				req.ExcludedWeeksSoft = true
+			},
+			weights: models.WeightOverrides{ClassRankPenalty: weight(150)},
+			raised:  models.WeightOverrides{ClassRankPenalty: weight(150), ExcludedWeekPenalty: weight(200)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, run := range []struct {
+				weights models.WeightOverrides
+				want    models.ClassNo
+			}{{tt.weights, "01"}, {tt.raised, "02"}} {
+				req := testRequest()
+				req.Modules = []string{"MOD0", "MOD1"}
+				req.ClassPreferences = map[string][]string{"MOD1|Tutorial": {"01", "02"}}
+				tt.setup(&req)
+				req.Weights = run.weights
+				parseTestRequest(t, &req)
+
+				space, err := buildSearchSpace(tt.timetables, &req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				result := searchTimetables(context.Background(), space.lessons, space.lessonToSlots, req)
+				if got := result.states[0].Assignments["MOD1|Tutorial"]; got != run.want {
+					t.Errorf("Expected tutorial %s with weights %+v, got %s", run.want, run.weights, got)
+				}
+			}
+		})
+	}
+}
+
+// parseTestRequest parses req again after a test changed its fields, and resolves its weights.
+func parseTestRequest(t *testing.T, req *models.OptimiserRequest) {
+	t.Helper()
+
+	if err := req.ParseOptimiserRequestFields(); err != nil {
+		t.Fatal(err)
+	}
+	weights, err := resolveScoringWeights(req.Weights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ScoringWeights = weights
+}
+
+// assignClasses builds the search space of timetables for the parsed req and assigns the
+// class of every lesson given in classes, returning the timetable.
+func assignClasses(
+	t *testing.T,
+	timetables map[string][]models.ModuleSlot,
+	req *models.OptimiserRequest,
+	classes map[string]models.ClassNo,
+) models.TimetableState {
+	t.Helper()
+
+	space, err := buildSearchSpace(timetables, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := newEmptyState(*req)
+	for _, lessonKey := range space.lessons {
+		for _, group := range space.lessonToSlots[lessonKey] {
+			if group[0].ClassNo == classes[lessonKey] {
+				state = assignGroup(state, lessonKey, group, req.ScoringWeights)
+			}
+		}
+	}
+	if len(state.Assignments) != len(classes) {
+		t.Fatalf("Expected the classes %v assigned, got %v", classes, state.Assignments)
+	}
+	return state
+}
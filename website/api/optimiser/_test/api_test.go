@@ -3,12 +3,17 @@ package test
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/umahmood/haversine"
+
 	models "github.com/nusmodifications/nusmods/website/api/optimiser/_models"
 )
 
@@ -512,6 +517,853 @@ func TestOptimiser_PinnedSlotMalformed(t *testing.T) {
 	}
 }
 
+// TestOptimiser_NegativeTimeLimitRejected verifies that a negative time limit is
+// rejected with 400.
+func TestOptimiser_NegativeTimeLimitRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.TimeLimitMs = -1
+
+	resp, _ := makeRequest(t, req)
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for negative time limit, got %d", resp.StatusCode)
+	}
+}
+
+// TestOptimiser_Alternatives verifies that requested alternatives are complete,
+// scored no better than the best timetable and differ from it and from each other
+// in at least two lessons.
+func TestOptimiser_Alternatives(t *testing.T) {
+	req := models.OptimiserRequest{
+		Modules:             []string{"CS2040S", "CS2030S", "ST2334"},
+		Recordings:          []string{},
+		FreeDays:            []string{},
+		EarliestTime:        "0800",
+		LatestTime:          "1900",
+		AcadYear:            "2025-2026",
+		AcadSem:             1,
+		MaxConsecutiveHours: 4,
+		LunchStart:          "1200",
+		LunchEnd:            "1400",
+		Alternatives:        3,
+	}
+
+	result := solveOK(t, req)
+
+	if len(result.Alternatives) == 0 {
+		t.Fatal("Expected alternatives, got none")
+	}
+	if len(result.Alternatives) > req.Alternatives {
+		t.Errorf("Expected at most %d alternatives, got %d", req.Alternatives, len(result.Alternatives))
+	}
+
+	picked := []map[string]string{result.Assignments}
+	for i, alternative := range result.Alternatives {
+		if alternative.Score < result.Score {
+			t.Errorf("Alternative %d scored %.2f, better than the best timetable %.2f", i, alternative.Score, result.Score)
+		}
+		if alternative.ShareableLink == "" {
+			t.Errorf("Alternative %d has no shareable link", i)
+		}
+		if len(alternative.Assignments) != len(result.Assignments) {
+			t.Errorf("Alternative %d assigns %d lessons, expected %d",
+				i, len(alternative.Assignments), len(result.Assignments))
+		}
+		for _, other := range picked {
+			differences := 0
+			for lessonKey, classNo := range other {
+				if alternative.Assignments[lessonKey] != classNo {
+					differences++
+				}
+			}
+			if differences < 2 {
+				t.Errorf("Alternative %d differs in only %d lessons: %v", i, differences, alternative.Assignments)
+			}
+		}
+		picked = append(picked, alternative.Assignments)
+	}
+
+	t.Logf("✅ %d alternatives returned", len(result.Alternatives))
+}
+
+// TestOptimiser_SmallRequestProvenOptimal verifies that a single-module request is
+// small enough to be solved exactly and is reported as proven optimal.
+func TestOptimiser_SmallRequestProvenOptimal(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+
+	result := solveOK(t, req)
+
+	if !result.ProvenOptimal {
+		t.Error("Expected a single-module request to be proven optimal")
+	}
+	validateTimetable(t, result, req)
+
+	t.Logf("✅ Small request proven optimal. Score: %.2f", result.Score)
+}
+
+// TestOptimiser_UnassignedLessonsExplainFilters verifies that when every weekday is
+// free, the physical lessons are reported as filtered by the free days.
+func TestOptimiser_UnassignedLessonsExplainFilters(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.FreeDays = dayNames
+
+	result := solveOK(t, req)
+
+	if len(result.UnassignedLessons) == 0 {
+		t.Fatal("Expected unassigned lessons when every day is free, got none")
+	}
+	for _, unassigned := range result.UnassignedLessons {
+		if unassigned.Reason != models.UnassignedReasonFiltered {
+			t.Errorf("Expected %s to be filtered, got reason %q", unassigned.LessonKey, unassigned.Reason)
+			continue
+		}
+		if len(unassigned.FilteredBy) == 0 {
+			t.Errorf("Expected filter reasons for %s, got none", unassigned.LessonKey)
+		}
+		for _, reason := range unassigned.FilteredBy {
+			if !strings.HasPrefix(reason, models.FilterReasonFreeDay+":") {
+				t.Errorf("Expected only free day filters for %s, got %q", unassigned.LessonKey, reason)
+			}
+		}
+	}
+
+	t.Logf("✅ Unassigned lessons explained: %v", result.UnassignedLessons)
+}
+
+func TestOptimiser_RelaxationsMakeEveryLessonAssignable(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.FreeDays = []string{"Monday", "Tuesday", "Wednesday", "Thursday"}
+
+	result := solveOK(t, req)
+	if len(result.UnassignedLessons) == 0 && len(result.Relaxations) > 0 {
+		t.Fatalf("Expected no relaxations for a feasible request, got %v", result.Relaxations)
+	}
+
+	for _, relaxation := range result.Relaxations {
+		if len(relaxation.Changes) == 0 || relaxation.ShareableLink == "" {
+			t.Fatalf("Expected changes and a shareable link, got %+v", relaxation)
+		}
+
+		relaxed := req
+		relaxed.FreeDays = slices.Clone(req.FreeDays)
+		for _, change := range relaxation.Changes {
+			switch change.Kind {
+			case models.RelaxationDropFreeDay:
+				relaxed.FreeDays = slices.DeleteFunc(relaxed.FreeDays, func(day string) bool {
+					return day == change.Value
+				})
+			case models.RelaxationEarlierStart:
+				relaxed.EarliestTime = change.Value
+			case models.RelaxationLaterEnd:
+				relaxed.LatestTime = change.Value
+			case models.RelaxationRecordLesson:
+				relaxed.Recordings = append(slices.Clone(relaxed.Recordings), change.Value)
+			default:
+				t.Fatalf("Unexpected relaxation kind %q", change.Kind)
+			}
+		}
+
+		relaxedResult := solveOK(t, relaxed)
+		if len(relaxedResult.UnassignedLessons) > 0 {
+			t.Errorf(
+				"Expected relaxation %v to assign every lesson, got %v",
+				relaxation.Changes,
+				relaxedResult.UnassignedLessons,
+			)
+		}
+	}
+
+	t.Logf("✅ Relaxations: %v", result.Relaxations)
+}
+
+func TestOptimiser_ScoringWeightOverrides(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	defaultResult := solveOK(t, req)
+
+	// With lunch worth nothing and no other penalty to pay, only walking is scored
+	zero := 0.0
+	req.Weights = models.WeightOverrides{
+		LunchBonus:                  &zero,
+		NoLunchPenalty:              &zero,
+		GapPenaltyRate:              &zero,
+		ConsecutiveHoursPenaltyRate: &zero,
+	}
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	if result.Score < 0 {
+		t.Errorf("Expected a non-negative score without the lunch bonus, got %.2f", result.Score)
+	}
+	if result.Score != result.TotalDistance {
+		t.Errorf("Expected score to equal the walking penalty %.2f, got %.2f", result.TotalDistance, result.Score)
+	}
+
+	t.Logf("✅ Default score %.2f, walking-only score %.2f", defaultResult.Score, result.Score)
+}
+
+func TestOptimiser_InvalidScoringWeightRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	negative := -1.0
+	req.Weights = models.WeightOverrides{MaxWalkDistance: &negative}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a negative maxWalkDistance, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+func TestOptimiser_ScoreBreakdownAddsUpToScore(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S"}
+
+	result := solveOK(t, req)
+
+	var total float64
+	for _, score := range result.ScoreBreakdown.Total {
+		total += score
+	}
+	if math.Abs(total-result.Score) > 1e-6 {
+		t.Errorf("Expected breakdown total %.6f to equal score %.6f", total, result.Score)
+	}
+
+	for component, componentTotal := range result.ScoreBreakdown.Total {
+		sum := result.ScoreBreakdown.Week[component]
+		for _, day := range result.ScoreBreakdown.Days {
+			sum += day[component]
+		}
+		if math.Abs(sum-componentTotal) > 1e-6 {
+			t.Errorf("Expected %s days and week to add up to %.6f, got %.6f", component, componentTotal, sum)
+		}
+	}
+
+	t.Logf("✅ Score breakdown: %v", result.ScoreBreakdown.Total)
+}
+
+func TestOptimiser_MinimiseCampusDays(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S", "ST2334"}
+	campusDays := func(result models.SolveResponse) int {
+		days := 0
+		for _, slots := range result.DaySlots {
+			if len(slots) > 0 {
+				days++
+			}
+		}
+		return days
+	}
+
+	defaultResult := solveOK(t, req)
+	req.MinimiseCampusDays = true
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	if campusDays(result) > campusDays(defaultResult) {
+		t.Errorf("Expected at most %d campus days, got %d", campusDays(defaultResult), campusDays(result))
+	}
+	penalty := result.ScoreBreakdown.Total["campusDays"]
+	if want := 500.0 * float64(campusDays(result)); penalty != want {
+		t.Errorf("Expected campus days penalty %.0f, got %.0f", want, penalty)
+	}
+
+	t.Logf("✅ Campus days: %d by default, %d when minimised", campusDays(defaultResult), campusDays(result))
+}
+
+func TestOptimiser_BlackoutsExcludeLessons(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S"}
+	req.Blackouts = []models.Blackout{
+		{Day: "Tuesday", StartTime: "1000", EndTime: "1400"},
+		{Day: "Friday", StartTime: "1200", EndTime: "1400"},
+	}
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	for _, blackout := range req.Blackouts {
+		startMin, _ := models.ParseTimeToMinutes(blackout.StartTime)
+		endMin, _ := models.ParseTimeToMinutes(blackout.EndTime)
+		for _, slot := range result.DaySlots[slices.Index(dayNames, blackout.Day)] {
+			if slot.StartMin < endMin && startMin < slot.EndMin {
+				t.Errorf("Expected no lesson in blackout %s, got %s at %s-%s",
+					blackout, slot.LessonKey, slot.StartTime, slot.EndTime)
+			}
+		}
+	}
+
+	t.Logf("✅ Blackouts respected. Assignments: %v", result.Assignments)
+}
+
+func TestOptimiser_InvalidBlackoutRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Blackouts = []models.Blackout{{Day: "Tuesday", StartTime: "2000", EndTime: "1800"}}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an empty blackout, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+func TestOptimiser_DayTimeWindows(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S"}
+	req.DayTimeWindows = map[string]models.TimeWindow{
+		"Monday": {EarliestTime: "1200"},
+		"Friday": {LatestTime: "1300"},
+	}
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	for _, slot := range result.DaySlots[0] {
+		if slot.StartMin < 12*60 {
+			t.Errorf("Expected no Monday lesson before 1200, got %s at %s", slot.LessonKey, slot.StartTime)
+		}
+	}
+	for _, slot := range result.DaySlots[4] {
+		if slot.EndMin > 13*60 {
+			t.Errorf("Expected no Friday lesson after 1300, got %s ending %s", slot.LessonKey, slot.EndTime)
+		}
+	}
+
+	t.Logf("✅ Per-day time windows respected. Assignments: %v", result.Assignments)
+}
+
+func TestOptimiser_InvalidDayTimeWindowRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.DayTimeWindows = map[string]models.TimeWindow{"Funday": {EarliestTime: "1000"}}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an invalid day, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+func TestOptimiser_MaxHoursPerDay(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S", "ST2334"}
+	req.MaxHoursPerDay = 4
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	for d, slots := range result.DaySlots {
+		minutes := 0
+		for _, slot := range slots {
+			minutes += slot.EndMin - slot.StartMin
+		}
+		if minutes > 4*60 {
+			t.Errorf("Expected at most 4 hours on %s, got %.1f", dayNames[d], float64(minutes)/60)
+		}
+	}
+	for _, unassigned := range result.UnassignedLessons {
+		if unassigned.Reason == models.UnassignedReasonMaxHoursPerDay {
+			t.Logf("   %s left out by maxHoursPerDay", unassigned.LessonKey)
+		}
+	}
+
+	t.Logf("✅ Daily hours capped. Assignments: %v", result.Assignments)
+}
+
+// TestOptimiser_MaxHoursPerDaySoft verifies that a soft cap penalises the hours over it at
+// dailyHoursPenaltyRate instead of leaving lessons out.
+func TestOptimiser_MaxHoursPerDaySoft(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S", "ST2334"}
+	req.MaxHoursPerDay = 2
+	req.MaxHoursPerDaySoft = true
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	for _, unassigned := range result.UnassignedLessons {
+		if unassigned.Reason == models.UnassignedReasonMaxHoursPerDay {
+			t.Errorf("Expected a soft cap to leave no lesson out, got %s", unassigned.LessonKey)
+		}
+	}
+	if result.ScoreBreakdown.Total["dailyHours"] == 0 {
+		t.Fatalf("Expected a day over 2 hours to be penalised, got %v", result.DayHours)
+	}
+
+	t.Logf("✅ Hours over the soft cap: %.2f penalty (%v)", result.ScoreBreakdown.Total["dailyHours"], result.DayHours)
+}
+
+func TestOptimiser_BalanceDailyHours(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S", "ST2334"}
+	spread := func(result models.SolveResponse) float64 {
+		weekdays := result.DayHours[:5]
+		return slices.Max(weekdays) - slices.Min(weekdays)
+	}
+
+	defaultResult := solveOK(t, req)
+	req.BalanceDailyHours = true
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	for d, slots := range result.DaySlots {
+		minutes := 0
+		for _, slot := range slots {
+			minutes += slot.EndMin - slot.StartMin
+		}
+		if math.Abs(result.DayHours[d]-float64(minutes)/60) > 1e-6 {
+			t.Errorf("Expected %.2f hours on %s, got %.2f", float64(minutes)/60, dayNames[d], result.DayHours[d])
+		}
+	}
+	if result.DayHours[5] == 0 {
+		penalty := result.ScoreBreakdown.Total["balance"]
+		if want := 50 * spread(result); math.Abs(penalty-want) > 1e-6 {
+			t.Errorf("Expected balance penalty %.1f, got %.1f", want, penalty)
+		}
+	}
+
+	t.Logf("✅ Weekday spread: %.1f hours by default, %.1f when balanced (%v)",
+		spread(defaultResult), spread(result), result.DayHours)
+}
+
+func TestOptimiser_InvalidMaxHoursPerDayRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.MaxHoursPerDay = 25
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for maxHoursPerDay over 24, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+func TestOptimiser_WalkingSpeedLeavesTimeToTravel(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S", "ST2334"}
+	req.WalkingSpeed = 80
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	for d, slots := range result.DaySlots {
+		for i := range slots {
+			for j := i + 1; j < len(slots); j++ {
+				a, b := slots[i], slots[j]
+				if b.StartMin < a.StartMin {
+					a, b = b, a
+				}
+				if b.StartMin < a.EndMin || a.Coordinates.X < 0 || b.Coordinates.X < 0 {
+					continue
+				}
+				_ = a.ParseModuleSlotFields(a.LessonKey)
+				_ = b.ParseModuleSlotFields(b.LessonKey)
+				if !weeksOverlap(a.WeeksSet, b.WeeksSet) {
+					continue
+				}
+				_, km := haversine.Distance(
+					haversine.Coord{Lat: a.Coordinates.Y, Lon: a.Coordinates.X},
+					haversine.Coord{Lat: b.Coordinates.Y, Lon: b.Coordinates.X},
+				)
+				if walk := km * 1000 / req.WalkingSpeed; float64(b.StartMin-a.EndMin) < walk {
+					t.Errorf("%s: %s ends at %s, too late to walk %.0f min to %s at %s",
+						dayNames[d], a.LessonKey, a.EndTime, walk, b.LessonKey, b.StartTime)
+				}
+			}
+		}
+	}
+
+	t.Logf("✅ Every break leaves time to walk. Assignments: %v", result.Assignments)
+}
+
+// TestOptimiser_TravelTimeSoft verifies that a soft travel time penalises each minute short of
+// the walk between two lessons at travelTimePenaltyRate.
+func TestOptimiser_TravelTimeSoft(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S", "ST2334"}
+	// Slow enough that no break leaves time to walk between two venues
+	req.WalkingSpeed = 1
+	req.TravelTimeSoft = true
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	if result.ScoreBreakdown.Total["travelTime"] == 0 {
+		t.Fatalf("Expected a walk between lessons to be penalised, got %v", result.ScoreBreakdown.Total)
+	}
+
+	t.Logf("✅ Travel time penalty: %.2f", result.ScoreBreakdown.Total["travelTime"])
+}
+
+func TestOptimiser_NegativeWalkingSpeedRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.WalkingSpeed = -80
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a negative walkingSpeed, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+func TestOptimiser_HomeLocationCommute(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S"}
+	req.HomeLocation = &models.HomeLocation{Venue: "LT17"}
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	for d, slots := range result.DaySlots {
+		commute := result.ScoreBreakdown.Days[d]["commute"]
+		if len(slots) == 0 && commute != 0 {
+			t.Errorf("Expected no commute on %s without lessons, got %.2f", dayNames[d], commute)
+		}
+		if commute < 0 {
+			t.Errorf("Expected a non-negative commute on %s, got %.2f", dayNames[d], commute)
+		}
+	}
+
+	t.Logf("✅ Commute from LT17: %.2f. Assignments: %v", result.ScoreBreakdown.Total["commute"], result.Assignments)
+}
+
+func TestOptimiser_UnknownHomeVenueRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.HomeLocation = &models.HomeLocation{Venue: "NOT-A-VENUE"}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an unknown home venue, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+func TestOptimiser_AvoidedVenuesHard(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S"}
+	req.AvoidedVenues = []string{"LT19", "COM1"}
+	req.AvoidVenuesHard = true
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	for d, slots := range result.DaySlots {
+		for _, slot := range slots {
+			building, _, _ := strings.Cut(slot.Venue, "-")
+			if slices.Contains(req.AvoidedVenues, slot.Venue) || slices.Contains(req.AvoidedVenues, building) {
+				t.Errorf("%s: Expected no lesson in an avoided venue, got %s in %s", dayNames[d], slot.LessonKey, slot.Venue)
+			}
+		}
+	}
+	for _, unassigned := range result.UnassignedLessons {
+		t.Logf("   %s unassigned: %s %v", unassigned.LessonKey, unassigned.Reason, unassigned.FilteredBy)
+	}
+
+	t.Logf("✅ Avoided venues respected. Assignments: %v", result.Assignments)
+}
+
+func TestOptimiser_VenueBothPreferredAndAvoidedRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.PreferredVenues = []string{"COM1"}
+	req.AvoidedVenues = []string{"com1"}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a preferred and avoided venue, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+// TestOptimiser_RestrictToPreferences ranks a single class of a lesson from a baseline
+// solve, like TestOptimiser_PinnedSlotAssigned, so restricting to it must assign it.
+func TestOptimiser_RestrictToPreferences(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	baseline := solveOK(t, req)
+
+	var lessonKey, classNo string
+	for key, class := range baseline.Assignments {
+		lessonKey, classNo = key, class
+		break
+	}
+	if lessonKey == "" {
+		t.Fatal("Baseline solve produced no assignments")
+	}
+
+	req.ClassPreferences = map[string][]string{lessonKey: {classNo}}
+	req.RestrictToPreferences = true
+	result := solveOK(t, req)
+
+	if got := result.Assignments[lessonKey]; got != classNo {
+		t.Errorf("Expected preferred class %s for %s, got %q", classNo, lessonKey, got)
+	}
+	validateTimetable(t, result, req)
+
+	t.Logf("✅ Restricted to preferred class. %s -> %s", lessonKey, classNo)
+}
+
+func TestOptimiser_UnknownPreferredClassRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.ClassPreferences = map[string][]string{"CS2040S|Tutorial": {"01", "ZZZ99"}}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an unknown preferred class, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+// TestOptimiser_ExcludedSlotNotAssigned excludes the class a baseline solve assigned, like
+// TestOptimiser_PinnedSlotAssigned, so the solve must pick another class or none.
+func TestOptimiser_ExcludedSlotNotAssigned(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	baseline := solveOK(t, req)
+
+	var lessonKey, classNo string
+	for key, class := range baseline.Assignments {
+		lessonKey, classNo = key, class
+		break
+	}
+	if lessonKey == "" {
+		t.Fatal("Baseline solve produced no assignments")
+	}
+
+	req.ExcludedSlots = []string{lessonKey + "|" + classNo}
+	result := solveOK(t, req)
+
+	if got := result.Assignments[lessonKey]; got == classNo {
+		t.Errorf("Expected excluded class %s for %s not to be assigned", classNo, lessonKey)
+	}
+	validateTimetable(t, result, req)
+
+	t.Logf("✅ Excluded slot avoided. %s -> %q", lessonKey, result.Assignments[lessonKey])
+}
+
+func TestOptimiser_ExcludedSlotNonExistentClass(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.ExcludedSlots = []string{"CS2040S|Tutorial|ZZZ99"}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a non-existent excluded class, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+// TestOptimiser_OptionalModulesChooseBest takes one of two candidate modules on top of
+// CS2040S, so exactly one of them must be chosen and appear in the assignments.
+func TestOptimiser_OptionalModulesChooseBest(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.OptionalModules = []string{"CS2030S", "MA1521"}
+	req.ModuleCount = 2
+	result := solveOK(t, req)
+
+	if len(result.ChosenModules) != 1 {
+		t.Fatalf("Expected 1 chosen module, got %v", result.ChosenModules)
+	}
+	chosen := result.ChosenModules[0]
+	if chosen != "CS2030S" && chosen != "MA1521" {
+		t.Fatalf("Expected a candidate to be chosen, got %s", chosen)
+	}
+	for lessonKey := range result.Assignments {
+		module := strings.Split(lessonKey, "|")[0]
+		if module != "CS2040S" && module != chosen {
+			t.Errorf("Expected only CS2040S and %s to be assigned, got %s", chosen, lessonKey)
+		}
+	}
+	for _, combination := range result.ModuleCombinations {
+		if len(combination.Modules) != 1 || combination.Modules[0] == chosen {
+			t.Errorf("Expected runner-ups to be the other candidate, got %v", combination.Modules)
+		}
+	}
+	validateTimetable(t, result, req)
+
+	t.Logf("✅ Chose %s. Score: %.2f, runner-ups: %v", chosen, result.Score, result.ModuleCombinations)
+}
+
+func TestOptimiser_InvalidModuleCountRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.OptionalModules = []string{"CS2030S", "MA1521"}
+	req.ModuleCount = 4
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a moduleCount over the candidates, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+// TestOptimiser_WeekScoring solves a request with lessons in some weeks only under each
+// weekScoring, checking that the breakdown still adds up to the score.
+func TestOptimiser_WeekScoring(t *testing.T) {
+	for _, weekScoring := range []string{models.WeekScoringMean, models.WeekScoringWorst} {
+		req := pinnedSlotBaseRequest()
+		req.Modules = []string{"CS2040S", "CS2030S"}
+		req.WeekScoring = weekScoring
+		result := solveOK(t, req)
+
+		var total float64
+		for _, score := range result.ScoreBreakdown.Total {
+			total += score
+		}
+		if math.Abs(total-result.Score) > 1e-6 {
+			t.Errorf("%s: expected breakdown total %.6f to equal score %.6f", weekScoring, total, result.Score)
+		}
+		validateTimetable(t, result, req)
+
+		t.Logf("✅ %s week scoring. Score: %.2f", weekScoring, result.Score)
+	}
+}
+
+func TestOptimiser_InvalidWeekScoringRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.WeekScoring = "median"
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an unknown weekScoring, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+// TestOptimiser_SlotWeeksParsed checks the WeeksString of every returned slot against its
+// weeks: the teaching weeks of a list, or the dates of a date range.
+func TestOptimiser_SlotWeeksParsed(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S", "MA1521"}
+	result := solveOK(t, req)
+
+	for _, slots := range result.DaySlots {
+		for _, slot := range slots {
+			switch weeks := slot.Weeks.(type) {
+			case []any:
+				weekStrings := make([]string, len(weeks))
+				for i, week := range weeks {
+					weekStrings[i] = fmt.Sprint(week)
+				}
+				if want := strings.Join(weekStrings, ","); slot.WeeksString != want {
+					t.Errorf("%s: expected WeeksString %q, got %q", slot.LessonKey, want, slot.WeeksString)
+				}
+			case map[string]any:
+				for _, date := range strings.Split(slot.WeeksString, ",") {
+					if _, err := time.Parse(time.DateOnly, date); err != nil {
+						t.Errorf("%s: expected WeeksString of dates, got %q", slot.LessonKey, slot.WeeksString)
+					}
+				}
+			}
+		}
+	}
+
+	t.Logf("✅ Weeks parsed for %d lessons", len(result.Assignments))
+}
+
+// TestOptimiser_ExcludedWeeksRespected checks that no assigned physical lesson meets in an
+// excluded week. Lessons meeting in it every week may be left unassigned instead.
+func TestOptimiser_ExcludedWeeksRespected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S"}
+	req.ExcludedWeeks = []int{2}
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	for _, slots := range result.DaySlots {
+		for _, slot := range slots {
+			if slices.Contains(req.Recordings, slot.LessonKey) {
+				continue
+			}
+			if _, ok := slot.WeeksSet[2]; ok || slot.WeeksSet == nil {
+				t.Errorf("Expected no lesson in excluded week 2, got %s|%s (weeks %s)",
+					slot.LessonKey, slot.ClassNo, slot.WeeksString)
+			}
+		}
+	}
+
+	t.Logf("✅ Excluded weeks respected. Assignments: %v, unassigned: %v",
+		result.Assignments, result.UnassignedLessons)
+}
+
+func TestOptimiser_InvalidExcludedWeekRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.ExcludedWeeks = []int{0}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for excluded week 0, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+// TestOptimiser_WeekRecordingSplitsSlot records the CS2040S lectures in week 2 only, with
+// week 2 excluded: the lectures' physical slots must skip week 2 and be kept, while their
+// recorded slots hold week 2 alone.
+func TestOptimiser_WeekRecordingSplitsSlot(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S"}
+	req.Recordings = []string{"CS2040S|Lecture|||2"}
+	req.ExcludedWeeks = []int{2}
+
+	result := solveOK(t, req)
+	validateTimetable(t, result, req)
+
+	if _, ok := result.Assignments["CS2040S|Lecture"]; !ok {
+		t.Fatalf("Expected CS2040S|Lecture to be assigned, got %v", result.Assignments)
+	}
+	for _, slots := range result.DaySlots {
+		for _, slot := range slots {
+			if slot.LessonKey != "CS2040S|Lecture" {
+				continue
+			}
+			_, inWeek2 := slot.WeeksSet[2]
+			if slot.Recorded != inWeek2 || (slot.Recorded && len(slot.WeeksSet) != 1) {
+				t.Errorf("Expected only week 2 of CS2040S|Lecture recorded, got recorded=%v in weeks %s",
+					slot.Recorded, slot.WeeksString)
+			}
+		}
+	}
+}
+
+func TestOptimiser_InvalidRecordingRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Recordings = []string{"CS2040S|Lecture|1|Funday"}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for recording day Funday, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+// TestOptimiser_RecordingUnknownClassRejected records a class CS2040S does not have, which
+// would otherwise record nothing.
+func TestOptimiser_RecordingUnknownClassRejected(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Recordings = []string{"CS2040S|Lecture|ZZZ99"}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for recording class ZZZ99, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
+// TestOptimiser_RecommendRecordingsUnlocksLectures keeps every weekday free, so no lecture is
+// assignable unless recorded: each recommended lecture must make a lesson assignable and be
+// recorded in the returned timetable.
+func TestOptimiser_RecommendRecordingsUnlocksLectures(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.Modules = []string{"CS2040S", "CS2030S"}
+	req.FreeDays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday"}
+	req.RecommendRecordings = 2
+
+	result := solveOK(t, req)
+
+	if len(result.RecommendedRecordings) != 2 {
+		t.Fatalf("Expected 2 recommended recordings, got %+v", result.RecommendedRecordings)
+	}
+	for _, recommendation := range result.RecommendedRecordings {
+		if !strings.HasSuffix(recommendation.Lesson, "|Lecture") || recommendation.LessonsGained < 1 {
+			t.Errorf("Expected a lecture that makes a lesson assignable, got %+v", recommendation)
+		}
+		if _, ok := result.Assignments[recommendation.Lesson]; !ok {
+			t.Errorf("Expected recommended %s to be assigned, got %v", recommendation.Lesson, result.Assignments)
+		}
+	}
+	req.Recordings = append(req.Recordings, result.RecommendedRecordings[0].Lesson, result.RecommendedRecordings[1].Lesson)
+	validateTimetable(t, result, req)
+}
+
+func TestOptimiser_RecordingCandidatesRequireRecommendRecordings(t *testing.T) {
+	req := pinnedSlotBaseRequest()
+	req.RecordingCandidates = []string{"CS2040S|Lecture"}
+
+	resp, body := makeRequest(t, req)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for recordingCandidates alone, got %d. Body: %s", resp.StatusCode, string(body))
+	}
+}
+
 // helpers
 
 // Day name constants for mapping
@@ -546,7 +1398,7 @@ func validateTimetable(t *testing.T, result models.SolveResponse, req models.Opt
 		for i, slot := range slots {
 			// Free days should only have recorded lessons (if any)
 			if freeDays[dayIdx] {
-				if !recordings[slot.LessonKey] {
+				if !recordings[slot.LessonKey] && !slot.Recorded {
 					t.Errorf("%s: Non-recorded lesson %s should not appear on free day",
 						dayNames[dayIdx], slot.LessonKey)
 				}
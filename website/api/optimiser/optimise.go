@@ -67,7 +67,7 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 	// what the client sent, even if the solve below times out or panics.
 	logger.InfoContext(ctx, "request received", "request", optimiserRequest)
 
-	response, err := solver.Solve(optimiserRequest)
+	response, err := solver.Solve(ctx, optimiserRequest)
 	if err != nil {
 		// A SolveError carries a specific status code and message; anything else
 		// is an internal server error.
@@ -107,6 +107,8 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 
 	logger.InfoContext(ctx, "solve succeeded",
 		"score", response.Score,
+		"truncated", response.Truncated,
+		"unassignedLessons", response.UnassignedLessons,
 		"durationMs", time.Since(start).Milliseconds(),
 		"shareableLink", response.ShareableLink,
 		"defaultShareableLink", response.DefaultShareableLink,